@@ -8,43 +8,103 @@ import (
   "io/ioutil"
   "strings"
   "flag"
+  "layout"
+  "os"
+  "interp"
+  "diag"
+  "loader"
 )
 
+// searchPath collects every -I flag, in the order they were given
+type searchPath []string
+
+func (s *searchPath) String() string {
+  return strings.Join(*s, string(os.PathListSeparator))
+}
+
+func (s *searchPath) Set(dir string) error {
+  *s = append(*s, dir)
+  return nil
+}
+
 func main() {
   lexPt := flag.Bool("lex", false, "Only lex the file into tokens. Don't parse.")
   parsePt := flag.Bool("parse", false, "Only lex & parse the file into an AST. Don't compile.")
   strBfPt := flag.Bool("str", false, "Show as BF descriptors.")
+  cellsPt := flag.Int("cells", compiler.DefaultOptions.Cells, "The number of cells available on the tape.")
+  layoutPt := flag.String("layout", "default", "How variables are placed on the tape, default or optimal.")
+  runPt := flag.Bool("run", false, "Compile and then run the program, using stdin and stdout.")
+  bitsPt := flag.Int("bits", compiler.DefaultOptions.CellBits, "The width of each cell in bits, 8, 16 or 32.")
+  wrapPt := flag.Bool("wrap", compiler.DefaultOptions.Wrap, "Whether cells wrap around on overflow and underflow.")
+  var paths searchPath
+  flag.Var(&paths, "I", "A directory to look for imports in, after the importing file's own. May be given more than once.")
 
   flag.Parse()
   tail := flag.Args()
+  if len(tail) == 0 {
+    fmt.Fprintln(os.Stderr, "Usage: bfukt [flags] file")
+    flag.PrintDefaults()
+    os.Exit(2)
+  }
 
-  f, err := ioutil.ReadFile(tail[0])
+  path := tail[0]
+  f, err := ioutil.ReadFile(path)
   if err != nil {
-    fmt.Printf("File Error: %v", err.Error())
+    fmt.Fprintf(os.Stderr, "File Error: %v\n", err.Error())
+    os.Exit(1)
+  }
+
+  if *layoutPt != "default" && *layoutPt != "optimal" {
+    fmt.Fprintf(os.Stderr, "Unknown layout: %v\n", *layoutPt)
+    os.Exit(2)
+  }
+
+  if *bitsPt != 8 && *bitsPt != 16 && *bitsPt != 32 {
+    fmt.Fprintf(os.Stderr, "Unsupported cell width: %v\n", *bitsPt)
+    os.Exit(2)
   }
 
   switch {
-  case *lexPt: printLexicons(f)
-  case *parsePt: printAst(f)
-  default: compile(f, *strBfPt)
+  case *lexPt: printLexicons(path, f)
+  case *parsePt: printAst(path, f, paths)
+  default:
+    opts := compiler.DefaultOptions
+    opts.Cells = *cellsPt
+    opts.CellBits = *bitsPt
+    opts.Wrap = *wrapPt
+    bf, diags := compile(path, f, paths, *strBfPt, *layoutPt == "optimal", opts)
+    report(diags)
+
+    if *runPt {
+      run(bf, opts)
+    } else {
+      fmt.Println(bf)
+    }
+  }
+}
+
+// report prints any diagnostics to stderr, and exits if there were errors
+func report(diags diag.List) {
+  if len(diags) > 0 {
+    fmt.Fprintln(os.Stderr, diags.String())
+  }
+
+  if diags.HasErrors() {
+    os.Exit(1)
   }
 }
 
-func printLexicons(f []byte) {
-  for tok := range parse.Lex(string(f)) {
+func printLexicons(path string, f []byte) {
+  for tok := range parse.LexFile(path, string(f)) {
     fmt.Printf("%v\n", tok)
   }
 }
 
-func printAst(f []byte) {
-  toks := parse.Lex(string(f))
-  ast, er := parse.Parse(toks)
+func printAst(path string, f []byte, paths []string) {
+  ast, diags := loader.New(paths).Load(path, f)
+  report(diags)
 
-  if er != nil {
-    fmt.Println(er.Error())
-  } else {
-    fmt.Printf(newLineOn(ast.String(), ";", "{", "}"))
-  }
+  fmt.Print(newLineOn(ast.String(), ";", "{", "}"))
 }
 
 func newLineOn(s string, breakChars ...string) string {
@@ -55,27 +115,67 @@ func newLineOn(s string, breakChars ...string) string {
   return s
 }
 
-func compile(f []byte, strBf bool) {
-  toks := parse.Lex(string(f))
-  ast, er := parse.Parse(toks)
+// compile returns the brainfuck for the file, unless there are any errors in
+// which case the output is incomplete and should not be used.
+func compile(path string, f []byte, paths []string, strBf bool, optimalLayout bool, opts compiler.Options) (string, diag.List) {
+  ast, diags := loader.New(paths).Load(path, f)
 
-  if er != nil {
-    fmt.Println(er.Error())
-    return
+  if diags.HasErrors() {
+    return "", diags
   }
 
   assembler, out := asm.New()
   go func() {
-    compiler.Compile(assembler, ast)
+    if optimalLayout {
+      compileWithLayout(assembler, ast, opts)
+    } else {
+      compiler.Compile(assembler, ast, opts)
+    }
     close(out)
   }()
 
-  for node := range out {
-    if strBf {
-      fmt.Println(node.String())
+  bf := strings.Builder{}
+  for node := range asm.Optimise(out) {
+    if d, isErr := asm.Diagnostic(node); isErr {
+      diags = append(diags, d)
+    } else if strBf {
+      bf.WriteString(node.String() + "\n")
     } else {
-      fmt.Printf("%s", node.ToBF())
+      bf.WriteString(node.ToBF())
     }
   }
-  fmt.Println("")
+
+  return bf.String(), diags
+}
+
+func run(bf string, opts compiler.Options) {
+  cfg := interp.DefaultConfig
+  cfg.TapeLen = opts.Cells
+  cfg.CellBits = opts.CellBits
+  cfg.Wrap = opts.Wrap
+
+  if err := interp.Run(bf, os.Stdin, os.Stdout, cfg); err != nil {
+    fmt.Fprintln(os.Stderr, err.Error())
+    os.Exit(1)
+  }
+}
+
+// compileWithLayout compiles the whole program up front so that the cells can
+// be rearranged to minimise pointer movement before anything is emitted.
+func compileWithLayout(assembler asm.Assembler, ast parse.StmtCollection, opts compiler.Options) {
+  recorder := asm.NewRecorder()
+  compiler.Compile(recorder, ast, opts)
+
+  if recorder.Rebased() {
+    fmt.Fprintln(os.Stderr, "Arrays indexed by variables can't be moved, leaving the layout as it is")
+    recorder.Replay(assembler, nil)
+    return
+  }
+
+  accesses := recorder.Accesses()
+  place := layout.Optimal(accesses)
+  fmt.Fprintf(os.Stderr, "Pointer movement: %d before layout, %d after\n",
+    layout.Cost(accesses, nil), layout.Cost(accesses, place))
+
+  recorder.Replay(assembler, place)
 }
@@ -1,6 +1,7 @@
 package asm
 
 import (
+	"diag"
 	"fmt"
 	"parse"
 	"strings"
@@ -22,6 +23,10 @@ type Assembler interface {
 
 	Add(pt Pointer, n int)
 
+	// Rebase moves to at and from then on calls that cell as. It lets a loop
+	// that moves the pointer along every time around close in step.
+	Rebase(at, as Pointer)
+
 	Comment(s string)
 	Err(expr parse.Expr, msg string, args ...interface{})
 }
@@ -68,6 +73,12 @@ func (a *assembler) CloseLoop() {
 	a.loops = a.loops[:len(a.loops)-1]
 }
 
+func (a *assembler) Rebase(at, as Pointer) {
+	a.move(at)
+	a.output <- bfRebase{int(at), int(as)}
+	a.pc = as
+}
+
 func (a *assembler) Print(pt Pointer) {
 	a.move(pt)
 	a.output <- bfPrint{}
@@ -83,7 +94,12 @@ func (a *assembler) Comment(s string) {
 }
 
 func (a *assembler) Err(expr parse.Expr, msg string, args ...interface{}) {
-	a.output <- bfErr{expr, fmt.Sprintf(msg, args...)}
+	var pos diag.Pos
+	if positioned, ok := expr.(parse.Positioned); ok {
+		pos = positioned.Position()
+	}
+
+	a.output <- bfErr{diag.Diagnostic{Pos: pos, Severity: diag.Error, Message: fmt.Sprintf(msg, args...)}}
 }
 
 type BfNode interface {
@@ -106,6 +122,18 @@ func (b bfMov) String() string {
 	return fmt.Sprintf("MOV %d %d", b.from, b.to)
 }
 
+// bfRebase renames the cell the pointer is on, it doesn't move anything
+type bfRebase struct {
+	at, as int
+}
+
+func (b bfRebase) ToBF() string {
+	return ""
+}
+func (b bfRebase) String() string {
+	return fmt.Sprintf("REBASE %d %d", b.at, b.as)
+}
+
 type bfAdd struct {
 	num int
 }
@@ -157,15 +185,23 @@ func (b bfRead) String() string {
 }
 
 type bfErr struct {
-	branch parse.Expr
-	reason string
+	diag diag.Diagnostic
 }
 
 func (b bfErr) ToBF() string {
-	return b.String()
+	return fmt.Sprintf("\n%v\n", b.diag)
 }
 func (b bfErr) String() string {
-	return fmt.Sprintf("\nCompiler Error: %v, %v\n", b.reason, b.branch)
+	return b.diag.String()
+}
+
+// Diagnostic returns the error carried by node, if it is an error
+func Diagnostic(node BfNode) (diag.Diagnostic, bool) {
+	if err, ok := node.(bfErr); ok {
+		return err.diag, true
+	}
+
+	return diag.Diagnostic{}, false
 }
 
 type bfComment struct {
@@ -43,3 +43,111 @@ func TestSimpleLoop(t *testing.T) {
 		assembler.CloseLoop()
 	})
 }
+
+func TestRebase(t *testing.T) {
+	expectBf(t, ">+++[->]<.", func(assembler asm.Assembler) {
+		assembler.Add(1, 3)
+		assembler.OpenLoop(1)
+		assembler.Add(1, -1)
+		assembler.Rebase(2, 1)
+		assembler.CloseLoop()
+		assembler.Print(0)
+	})
+}
+
+func TestOptimise(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+		m        assemblerMethod
+	}{
+		{"MergeAdds", ">+<.", func(a asm.Assembler) {
+			a.Add(1, 3)
+			a.Add(1, -2)
+			a.Print(0)
+		}},
+		{"CancelledAdds", ".", func(a asm.Assembler) {
+			a.Add(1, 3)
+			a.Add(1, -3)
+			a.Print(0)
+		}},
+		{"MergeMoves", ">>>.", func(a asm.Assembler) {
+			a.OpenLoop(1)
+			a.CloseLoop()
+			a.Print(3)
+		}},
+		{"DeadClearAtStart", ">+.", func(a asm.Assembler) {
+			a.OpenLoop(0)
+			a.Add(0, -1)
+			a.CloseLoop()
+			a.Add(1, 1)
+			a.Print(1)
+		}},
+		{"ClearAfterLoop", ",[-].", func(a asm.Assembler) {
+			a.Read(0)
+			a.OpenLoop(0)
+			a.Add(0, -1)
+			a.CloseLoop()
+			a.OpenLoop(0)
+			a.Add(0, -1)
+			a.CloseLoop()
+			a.Print(0)
+		}},
+		{"ClearAfterRead", ",[-]", func(a asm.Assembler) {
+			a.Read(0)
+			a.OpenLoop(0)
+			a.Add(0, -1)
+			a.CloseLoop()
+		}},
+		{"LoopOnKnownValue", "++[->+<]", func(a asm.Assembler) {
+			a.Add(0, 2)
+			a.OpenLoop(0)
+			a.Add(0, -1)
+			a.Add(1, 1)
+			a.CloseLoop()
+			a.OpenLoop(0)
+			a.Add(1, 1)
+			a.CloseLoop()
+		}},
+		{"RebaseForgetsValues", ">[-]>.", func(a asm.Assembler) {
+			a.Rebase(1, 0)
+			a.OpenLoop(0)
+			a.Add(0, -1)
+			a.CloseLoop()
+			a.Print(1)
+		}},
+	}
+
+	for _, test := range tests {
+		assembler, ch := asm.New()
+		go func() {
+			test.m(assembler)
+			close(ch)
+		}()
+
+		result := ""
+		for node := range asm.Optimise(ch) {
+			result += node.ToBF()
+		}
+
+		if result != test.expected {
+			t.Errorf("\n%v\nExpect:\t%v\nActual:\t%v", test.name, test.expected, result)
+		}
+	}
+}
+
+func TestRecorderLen(t *testing.T) {
+	r := asm.NewRecorder()
+	r.Add(2, 3)
+	r.OpenLoop(2)
+	r.Add(2, -1)
+	r.Add(0, 2)
+	r.Rebase(3, 2)
+	r.CloseLoop()
+	r.Print(1)
+
+	// +++[-<<++>>>]<.
+	if r.Len() != 15 {
+		t.Errorf("Expected a length of 15, got %d", r.Len())
+	}
+}
@@ -0,0 +1,162 @@
+package asm
+
+// knowledge is what the optimiser can prove about the tape at a point in the
+// stream. Until the first loop is entered every cell is known to be zero.
+type knowledge struct {
+	values  map[int]int
+	zeroed  bool
+	unknown map[int]bool
+}
+
+func newKnowledge(zeroed bool) *knowledge {
+	return &knowledge{make(map[int]int), zeroed, make(map[int]bool)}
+}
+
+func (k *knowledge) get(cell int) (int, bool) {
+	if v, ok := k.values[cell]; ok {
+		return v, true
+	}
+
+	return 0, k.zeroed && !k.unknown[cell]
+}
+
+func (k *knowledge) forget(cell int) {
+	delete(k.values, cell)
+	k.unknown[cell] = true
+}
+
+type peephole struct {
+	in  chan BfNode
+	out chan BfNode
+
+	pc    int
+	mov   *bfMov   // A move that hasn't been written yet
+	add   *bfAdd   // An add on pc that hasn't been written yet, after mov
+	queue []BfNode // Comments and errors waiting behind mov and add
+	known *knowledge
+}
+
+// Optimise rewrites the node stream of an assembler into an equivalent but
+// shorter one. Adjacent adds and moves are merged and loops over cells that are
+// provably zero (such as clears at the start of the program) are dropped.
+func Optimise(in chan BfNode) chan BfNode {
+	p := &peephole{
+		in:    in,
+		out:   make(chan BfNode),
+		known: newKnowledge(true),
+	}
+
+	go p.run()
+	return p.out
+}
+
+func (p *peephole) run() {
+	for node := range p.in {
+		switch n := node.(type) {
+		case bfMov:
+			if p.add != nil && p.add.num != 0 {
+				p.flush()
+			}
+			p.add = nil
+
+			if p.mov == nil {
+				p.mov = &bfMov{n.from, n.to}
+			} else {
+				p.mov.to += n.to - n.from
+			}
+			p.pc = n.to
+		case bfAdd:
+			if p.add == nil {
+				p.add = &bfAdd{0}
+			}
+			p.add.num += n.num
+			if v, ok := p.known.get(p.pc); ok {
+				p.known.values[p.pc] = v + n.num
+			}
+		case bfStartLoop:
+			if v, ok := p.known.get(p.pc); ok && v == 0 {
+				p.skipLoop()
+				continue
+			}
+			p.emit(node)
+			p.known = newKnowledge(false)
+		case bfEndLoop:
+			p.emit(node)
+			p.known = newKnowledge(false)
+			p.known.values[p.pc] = 0
+		case bfRebase:
+			// The pointer stays put, so a move either side can still be merged.
+			// Everything known is about cells that now have other names.
+			if p.add != nil && p.add.num != 0 {
+				p.flush()
+			}
+			p.add = nil
+
+			if p.mov != nil {
+				p.queue = append(p.queue, node)
+			} else {
+				p.out <- node
+			}
+			p.pc = n.as
+			p.known = newKnowledge(false)
+		case bfRead:
+			p.emit(node)
+			p.known.forget(p.pc)
+		case bfComment, bfErr:
+			if p.mov != nil || p.add != nil {
+				p.queue = append(p.queue, node)
+			} else {
+				p.out <- node
+			}
+		default:
+			p.emit(node)
+		}
+	}
+
+	// A trailing move can't affect anything
+	if p.add == nil || p.add.num == 0 {
+		p.mov = nil
+	}
+	p.flush()
+	close(p.out)
+}
+
+// skipLoop drops everything up to the matching end of the loop. The pointer
+// ends up back where it started, so pc doesn't change.
+func (p *peephole) skipLoop() {
+	for depth := 1; depth > 0; {
+		node, ok := <-p.in
+		if !ok {
+			return
+		}
+
+		switch n := node.(type) {
+		case bfStartLoop:
+			depth++
+		case bfEndLoop:
+			depth--
+		case bfErr:
+			p.queue = append(p.queue, n)
+		}
+	}
+}
+
+func (p *peephole) emit(node BfNode) {
+	p.flush()
+	p.out <- node
+}
+
+func (p *peephole) flush() {
+	if p.mov != nil && p.mov.from != p.mov.to {
+		p.out <- *p.mov
+	}
+	if p.add != nil && p.add.num != 0 {
+		p.out <- *p.add
+	}
+	p.mov, p.add = nil, nil
+
+	for _, node := range p.queue {
+		p.out <- node
+	}
+	p.queue = p.queue[:0]
+}
@@ -0,0 +1,181 @@
+package asm
+
+import (
+	"fmt"
+	"parse"
+)
+
+type opcode int
+
+const (
+	opRead opcode = iota
+	opPrint
+	opOpenLoop
+	opCloseLoop
+	opAdd
+	opRebase
+	opComment
+	opErr
+)
+
+type call struct {
+	op   opcode
+	pt   Pointer
+	n    int
+	s    string
+	expr parse.Expr
+}
+
+// Recorder is an Assembler that keeps the calls made on it, so that a whole
+// program can be inspected before being replayed into another Assembler.
+type Recorder struct {
+	calls   []call
+	rebased bool
+	failed  bool
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{calls: make([]call, 0, 100)}
+}
+
+func (r *Recorder) Read(pt Pointer) {
+	r.calls = append(r.calls, call{op: opRead, pt: pt})
+}
+
+func (r *Recorder) Print(pt Pointer) {
+	r.calls = append(r.calls, call{op: opPrint, pt: pt})
+}
+
+func (r *Recorder) OpenLoop(pt Pointer) {
+	r.calls = append(r.calls, call{op: opOpenLoop, pt: pt})
+}
+
+func (r *Recorder) CloseLoop() {
+	r.calls = append(r.calls, call{op: opCloseLoop})
+}
+
+func (r *Recorder) Add(pt Pointer, n int) {
+	r.calls = append(r.calls, call{op: opAdd, pt: pt, n: n})
+}
+
+func (r *Recorder) Rebase(at, as Pointer) {
+	r.calls = append(r.calls, call{op: opRebase, pt: at, n: int(as)})
+	r.rebased = true
+}
+
+// Rebased is whether the pointer was ever rebased. The cells either side of a
+// rebase are laid out relative to each other, so they can't be moved around.
+func (r *Recorder) Rebased() bool {
+	return r.rebased
+}
+
+func (r *Recorder) Comment(s string) {
+	r.calls = append(r.calls, call{op: opComment, s: s})
+}
+
+func (r *Recorder) Err(expr parse.Expr, msg string, args ...interface{}) {
+	r.calls = append(r.calls, call{op: opErr, expr: expr, s: fmt.Sprintf(msg, args...)})
+	r.failed = true
+}
+
+// Failed is whether any errors were recorded
+func (r *Recorder) Failed() bool {
+	return r.failed
+}
+
+// Len is the number of brainfuck instructions the recorded calls assemble to,
+// starting from the first cell they use and before any optimisation.
+func (r *Recorder) Len() int {
+	n, pc, started := 0, ZeroPointer, false
+	loops := make([]Pointer, 0, 10)
+	move := func(to Pointer) {
+		if started {
+			n += abs(int(to - pc))
+		}
+		pc, started = to, true
+	}
+
+	for _, c := range r.calls {
+		switch c.op {
+		case opRead, opPrint:
+			move(c.pt)
+			n++
+		case opAdd:
+			move(c.pt)
+			n += abs(c.n)
+		case opOpenLoop:
+			move(c.pt)
+			loops = append(loops, c.pt)
+			n++
+		case opCloseLoop:
+			move(loops[len(loops)-1])
+			loops = loops[:len(loops)-1]
+			n++
+		case opRebase:
+			move(c.pt)
+			pc = Pointer(c.n)
+		}
+	}
+
+	return n
+}
+
+// Accesses lists the cells the pointer visits, in the order it visits them
+func (r *Recorder) Accesses() []Pointer {
+	accesses := make([]Pointer, 0, len(r.calls))
+	loops := make([]Pointer, 0, 10)
+
+	for _, c := range r.calls {
+		switch c.op {
+		case opRead, opPrint, opAdd, opRebase:
+			accesses = append(accesses, c.pt)
+		case opOpenLoop:
+			accesses = append(accesses, c.pt)
+			loops = append(loops, c.pt)
+		case opCloseLoop:
+			accesses = append(accesses, loops[len(loops)-1])
+			loops = loops[:len(loops)-1]
+		}
+	}
+
+	return accesses
+}
+
+// Replay makes the recorded calls on a, moving every cell found in place to
+// its new position.
+func (r *Recorder) Replay(a Assembler, place map[Pointer]Pointer) {
+	relocate := func(pt Pointer) Pointer {
+		if to, ok := place[pt]; ok {
+			return to
+		}
+		return pt
+	}
+
+	for _, c := range r.calls {
+		switch c.op {
+		case opRead:
+			a.Read(relocate(c.pt))
+		case opPrint:
+			a.Print(relocate(c.pt))
+		case opOpenLoop:
+			a.OpenLoop(relocate(c.pt))
+		case opCloseLoop:
+			a.CloseLoop()
+		case opAdd:
+			a.Add(relocate(c.pt), c.n)
+		case opRebase:
+			a.Rebase(relocate(c.pt), relocate(Pointer(c.n)))
+		case opComment:
+			a.Comment(c.s)
+		case opErr:
+			a.Err(c.expr, "%s", c.s)
+		}
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,238 @@
+package compiler
+
+import (
+	"asm"
+	"parse"
+)
+
+// Scale multiplies the cell by n
+func (p *program) Scale(pt asm.Pointer, n int) {
+	temp := p.Temp(pt)
+	p.Move(pt, temp)
+	p.Drain(temp, map[asm.Pointer]int{pt: n})
+	p.Free(temp)
+}
+
+// ScaleBy multiplies the cell by the value of factor, which is left as it was
+func (p *program) ScaleBy(pt asm.Pointer, factor asm.Pointer) {
+	temp := p.Temp(pt)
+	p.Move(pt, temp)
+
+	p.asm.OpenLoop(temp)
+	p.asm.Add(temp, -1)
+	p.Copy(factor, pt)
+	p.asm.CloseLoop()
+
+	p.Free(temp)
+}
+
+// compileArith evaluates the expression into a new scratch cell, which the
+// caller must zero and free. It returns the null pointer if there was an error.
+func compileArith(p *program, expr parse.Arith, near asm.Pointer) asm.Pointer {
+	switch expr.Op {
+	case parse.Multiply:
+		return compileMultiply(p, expr.Lhs, expr.Rhs, near)
+	case parse.Divide:
+		quotient, _ := compileDivMod(p, expr.Lhs, expr.Rhs, near, false)
+		return quotient
+	case parse.Modulo:
+		_, remainder := compileDivMod(p, expr.Lhs, expr.Rhs, near, true)
+		return remainder
+	default:
+		p.asm.Err(p.stmt, "Unknown operator %v", expr.Op)
+		return asm.NullPointer
+	}
+}
+
+func compileMultiply(p *program, x, y parse.Expr, near asm.Pointer) asm.Pointer {
+	xLit, xIsLit := x.(parse.Lit)
+	yLit, yIsLit := y.(parse.Lit)
+
+	result := p.Temp(near)
+	switch {
+	case xIsLit && yIsLit:
+		addFolded(p, result, xLit.Val*yLit.Val, xLit)
+	case xIsLit:
+		addScaled(p, y.(parse.Ident), result, xLit.Val)
+	case yIsLit:
+		addScaled(p, x.(parse.Ident), result, yLit.Val)
+	default:
+		addProduct(p, x.(parse.Ident), y.(parse.Ident), result)
+	}
+
+	return result
+}
+
+// addFolded adds a value worked out from literals onto pt, checking it fits in
+// a cell as if it had been written as a literal where from is
+func addFolded(p *program, pt asm.Pointer, val int, from parse.Lit) {
+	p.checkRange(parse.Lit{Val: val, Pos: from.Pos}, false)
+	p.AddConst(pt, val)
+}
+
+// addScaled adds n times the value of id onto to
+func addScaled(p *program, id parse.Ident, to asm.Pointer, n int) {
+	pt, ok := p.GetPt(id)
+	if !ok {
+		return
+	}
+
+	if id.Op == parse.Floor {
+		p.Drain(pt, map[asm.Pointer]int{to: n})
+		return
+	}
+
+	temp := p.Temp(pt)
+	p.Drain(pt, map[asm.Pointer]int{to: n, temp: 1})
+	p.Move(temp, pt)
+	p.Free(temp)
+}
+
+// addProduct adds x * y onto to with the usual nested loop, counting down x
+// and copying y each time around. A floored operand is used up as the counter.
+func addProduct(p *program, x, y parse.Ident, to asm.Pointer) {
+	if y.Op == parse.Floor && x.Op != parse.Floor {
+		x, y = y, x
+	}
+
+	xPt, xOk := p.GetPt(x)
+	yPt, yOk := p.GetPt(y)
+	if !xOk || !yOk {
+		return
+	}
+
+	// Squaring would restore x into the cell being copied
+	copied := asm.NullPointer
+	if xPt == yPt {
+		copied = p.Temp(yPt)
+		p.Copy(yPt, copied)
+		yPt = copied
+	}
+
+	counter := xPt
+	if x.Op != parse.Floor {
+		counter = p.Temp(xPt)
+		p.Move(xPt, counter)
+	}
+
+	p.asm.OpenLoop(counter)
+	p.asm.Add(counter, -1)
+	if counter != xPt {
+		p.asm.Add(xPt, 1)
+	}
+	p.Copy(yPt, to)
+	p.asm.CloseLoop()
+
+	if counter != xPt {
+		p.Free(counter)
+	}
+	if copied != asm.NullPointer {
+		p.Clear(copied)
+		p.Free(copied)
+	}
+}
+
+// compileDivMod divides n by d into new scratch cells, only working out the
+// remainder when it is wanted. A countdown starts at d and is decremented
+// along with n, and every time it reaches zero the quotient goes up and the
+// countdown is reloaded. Once n runs out the remainder is d minus the
+// countdown. Dividing by a literal reloads with a constant instead of copying
+// d back in every time, which is much shorter.
+func compileDivMod(p *program, n, d parse.Expr, near asm.Pointer, wantRemainder bool) (asm.Pointer, asm.Pointer) {
+	nLit, nIsLit := n.(parse.Lit)
+	dLit, dIsLit := d.(parse.Lit)
+
+	numerator, divisor := asm.NullPointer, asm.NullPointer
+	ok := true
+	if !nIsLit {
+		numerator, ok = p.GetPt(n.(parse.Ident))
+	}
+	if !dIsLit && ok {
+		divisor, ok = p.GetPt(d.(parse.Ident))
+	}
+	if !ok {
+		return asm.NullPointer, asm.NullPointer
+	}
+
+	if dIsLit && dLit.Val == 0 {
+		p.asm.Err(p.stmt, "Division by zero")
+		return asm.NullPointer, asm.NullPointer
+	}
+
+	quotient, remainder := p.Temp(near), asm.NullPointer
+	if wantRemainder {
+		remainder = p.Temp(near)
+	}
+
+	if nIsLit && dIsLit {
+		addFolded(p, quotient, nLit.Val/dLit.Val, nLit)
+		if wantRemainder {
+			addFolded(p, remainder, nLit.Val%dLit.Val, nLit)
+		}
+		return quotient, remainder
+	}
+
+	// A floored numerator can be counted down in place, unless it's the divisor
+	counter := numerator
+	if nIsLit || n.(parse.Ident).Op != parse.Floor || numerator == divisor {
+		counter = p.Temp(near)
+		if nIsLit {
+			p.AddConst(counter, nLit.Val)
+		} else {
+			p.Copy(numerator, counter)
+		}
+	}
+
+	reload := func(pt asm.Pointer) {
+		if dIsLit {
+			p.AddConst(pt, dLit.Val)
+		} else {
+			p.Copy(divisor, pt)
+		}
+	}
+	divMod(p, counter, quotient, remainder, reload)
+
+	if counter != numerator {
+		p.Free(counter)
+	}
+
+	return quotient, remainder
+}
+
+// divMod drains counter, adding how many times the divisor goes into it onto
+// quotient and what's left over onto remainder, unless that's the null
+// pointer. reload adds the divisor onto a cell.
+func divMod(p *program, counter, quotient, remainder asm.Pointer, reload func(asm.Pointer)) {
+	countdown := p.Temp(counter)
+	reload(countdown)
+
+	flag, test := p.Temp(countdown), p.Temp(countdown)
+	p.asm.OpenLoop(counter)
+	p.asm.Add(counter, -1)
+	p.asm.Add(countdown, -1)
+
+	p.asm.Add(flag, 1)
+	p.Move(countdown, test)
+	p.asm.OpenLoop(test)
+	p.Move(test, countdown)
+	p.asm.Add(flag, -1)
+	p.asm.CloseLoop()
+
+	p.asm.OpenLoop(flag)
+	p.asm.Add(flag, -1)
+	p.asm.Add(quotient, 1)
+	reload(countdown)
+	p.asm.CloseLoop()
+	p.asm.CloseLoop()
+
+	p.Free(flag)
+	p.Free(test)
+
+	if remainder != asm.NullPointer {
+		reload(remainder)
+		p.Drain(countdown, map[asm.Pointer]int{remainder: -1})
+	} else {
+		p.Clear(countdown)
+	}
+	p.Free(countdown)
+}
@@ -0,0 +1,202 @@
+package compiler
+
+import (
+	"asm"
+	"parse"
+)
+
+// arrayStride is the number of cells each element of an array takes up
+const arrayStride = 3
+
+// array is the scope value of an array. Its cells are a sentinel, which is
+// always zero, followed by a slot for each element. A slot holds the value
+// being carried to or from the element, the element itself, and the index
+// which is counted down to find it.
+//
+// Indexing by a variable walks the pointer along the slots at runtime, so
+// while it is out there the cells of the first slot refer to whichever slot
+// it has reached.
+type array struct {
+	base asm.Pointer
+	size int
+}
+
+func (a *array) carry(k int) asm.Pointer {
+	return a.base + asm.Pointer(1+arrayStride*k)
+}
+
+func (a *array) data(k int) asm.Pointer {
+	return a.carry(k) + 1
+}
+
+func (a *array) index(k int) asm.Pointer {
+	return a.carry(k) + 2
+}
+
+func (a *array) cells() int {
+	return 1 + arrayStride*a.size
+}
+
+// defArray reserves a block of cells for the array. The elements are released
+// like any other variable when the scope exits, the rest of the block is
+// always zero so it only needs freeing.
+func defArray(p *program, id parse.Ident) {
+	size, isLit := id.Index.(parse.Lit)
+	if !isLit || size.Val < 1 {
+		p.asm.Err(id, "The size of %v must be a literal of at least 1", id.Id)
+		return
+	}
+
+	arr := &array{size: size.Val}
+	base, err := p.mem.MallocBlock(arr.cells(), -1)
+	if err != nil {
+		p.outOfMemory(id)
+		return
+	}
+	arr.base = asm.Pointer(base)
+
+	if _, err := p.sc.Define(&id.Id, arr); err != nil {
+		p.asm.Err(id, "Cannot redefine variable within the same scope")
+		for i := 0; i < arr.cells(); i++ {
+			p.Free(arr.base + asm.Pointer(i))
+		}
+		return
+	}
+
+	top := len(p.locals) - 1
+	p.reserved[top] = append(p.reserved[top], arr.base)
+	for k := 0; k < arr.size; k++ {
+		p.locals[top] = append(p.locals[top], arr.data(k))
+		p.reserved[top] = append(p.reserved[top], arr.carry(k), arr.index(k))
+	}
+}
+
+// element is the cell of an array element indexed by a literal
+func (p *program) element(id parse.Ident, arr *array) (asm.Pointer, bool) {
+	switch index := id.Index.(type) {
+	case nil:
+		p.asm.Err(id, "%v is an array, expected a variable", id.Id)
+	case parse.Lit:
+		if index.Val < 0 || index.Val >= arr.size {
+			p.asm.Err(id, "Index %d is out of range, %v has %d elements", index.Val, id.Id, arr.size)
+			return asm.NullPointer, false
+		}
+		return arr.data(index.Val), true
+	default:
+		p.asm.Err(id, "%v can only be indexed by a variable when assigning to or from it", id.Id)
+	}
+
+	return asm.NullPointer, false
+}
+
+// dynamicElement is the array when id is an element indexed by a variable
+func dynamicElement(p *program, id parse.Ident) (*array, bool) {
+	if _, isIdent := p.substitute(id.Index).(parse.Ident); !isIdent {
+		return nil, false
+	}
+
+	variable, ok := p.sc.Get(id.Id)
+	if !ok {
+		return nil, false
+	}
+
+	arr, isArray := variable.Value.(*array)
+	return arr, isArray
+}
+
+// compileArrayWrite sets, adds or subtracts rhs onto the element
+func compileArrayWrite(p *program, target parse.Ident, arr *array, rhs parse.Expr) {
+	if target.Op != parse.None && target.Op != parse.Add && target.Op != parse.Sub {
+		p.asm.Err(target, "Unexpected operator on an array element")
+		return
+	}
+
+	value := arr.carry(0)
+	switch rhs := rhs.(type) {
+	case parse.Lit:
+		p.AddConst(value, rhs.Val)
+	case parse.Ident:
+		if from, isElement := dynamicElement(p, rhs); isElement {
+			if read := compileArrayRead(p, rhs, from); read != value {
+				p.Move(read, value)
+			}
+		} else {
+			loadOperand(p, rhs, value, 1)
+		}
+	case parse.Arith:
+		src := compileArith(p, rhs, value)
+		if src == asm.NullPointer {
+			return
+		}
+		p.Move(src, value)
+		p.Free(src)
+	}
+
+	loadOperand(p, target.Index, arr.index(0), 1)
+	walkOut(p, arr, true)
+
+	switch target.Op {
+	case parse.None:
+		p.Clear(arr.data(0))
+		p.Move(value, arr.data(0))
+	case parse.Add:
+		p.Move(value, arr.data(0))
+	case parse.Sub:
+		p.Drain(value, map[asm.Pointer]int{arr.data(0): -1})
+	}
+
+	walkBack(p, arr, false)
+}
+
+// compileArrayRead fetches the element into the first carry cell of the
+// array, which the caller must zero again. A floored element is left at zero.
+func compileArrayRead(p *program, src parse.Ident, arr *array) asm.Pointer {
+	if src.Op != parse.None && src.Op != parse.Floor {
+		p.asm.Err(src, "Unexpected operator on an array element")
+		return asm.NullPointer
+	}
+
+	loadOperand(p, src.Index, arr.index(0), 1)
+	walkOut(p, arr, false)
+
+	if src.Op == parse.Floor {
+		p.Move(arr.data(0), arr.carry(0))
+	} else {
+		// The index has run down to zero, so it's free to restore from
+		p.Drain(arr.data(0), map[asm.Pointer]int{arr.carry(0): 1, arr.index(0): 1})
+		p.Move(arr.index(0), arr.data(0))
+	}
+
+	walkBack(p, arr, true)
+	return arr.carry(0)
+}
+
+// walkOut moves the pointer along to the slot whose number is in the first
+// index cell, marking every slot it leaves with a one. Nothing may be
+// allocated until walkBack, as the pointer is no longer where the allocator
+// thinks it is.
+func walkOut(p *program, arr *array, carry bool) {
+	p.asm.OpenLoop(arr.index(0))
+	p.asm.Add(arr.index(0), -1)
+	p.Move(arr.index(0), arr.index(1))
+	if carry {
+		p.Move(arr.carry(0), arr.carry(1))
+	}
+	p.asm.Add(arr.index(0), 1)
+	p.asm.Rebase(arr.index(1), arr.index(0))
+	p.asm.CloseLoop()
+}
+
+// walkBack follows the marks back to the first slot, clearing them on the
+// way, until it finds the sentinel.
+func walkBack(p *program, arr *array, carry bool) {
+	p.asm.Rebase(arr.index(0), arr.index(1))
+	p.asm.OpenLoop(arr.index(0))
+	p.asm.Add(arr.index(0), -1)
+	if carry {
+		p.Move(arr.carry(1), arr.carry(0))
+	}
+	p.asm.Rebase(arr.index(0), arr.index(1))
+	p.asm.CloseLoop()
+	p.asm.Rebase(arr.index(0), arr.base)
+}
@@ -2,17 +2,46 @@ package compiler
 
 import (
 	"asm"
+	"math"
 	"memory"
 	"parse"
 	"reflect"
 	"scope"
 	"sort"
+	"strings"
 )
 
+// function is the scope value of a def. Calls are inlined into the caller with
+// the arguments bound by reference, so a function never owns any cells itself.
+type function struct {
+	dec      parse.FuncDec
+	sc       *scope.Scope // The scope the function was declared in
+	inlining bool         // Set while the body is being inlined, to catch recursion
+}
+
+// Options configure the target machine the program is compiled for
+type Options struct {
+	Cells    int  // The length of the tape
+	CellBits int  // The width of each cell
+	Wrap     bool // Whether cells wrap around on overflow and underflow
+}
+
+var DefaultOptions = Options{
+	Cells:    memory.DefaultLimit,
+	CellBits: 8,
+	Wrap:     true,
+}
+
 type program struct {
-	sc  *scope.Scope
-	mem *memory.Memory
-	asm asm.Assembler
+	opts     Options
+	sc       *scope.Scope
+	mem      *memory.Memory
+	asm      asm.Assembler
+	locals   [][]asm.Pointer // Cells owned by each scope that has been entered
+	reserved [][]asm.Pointer // Cells owned by each scope which are always left at zero
+	stmt     parse.Expr      // The statement being compiled, for errors without a better subject
+	full     bool            // Whether running out of memory has been reported for stmt
+	loops    []*loop         // The loops being compiled that break or continue, innermost last
 }
 
 func (p *program) GetPt(id parse.Ident) (asm.Pointer, bool) {
@@ -22,6 +51,26 @@ func (p *program) GetPt(id parse.Ident) (asm.Pointer, bool) {
 		return asm.NullPointer, false
 	}
 
+	if _, isFunc := variable.Value.(*function); isFunc {
+		p.asm.Err(id, "%v is a function, expected a variable", id.Id)
+		return asm.NullPointer, false
+	}
+
+	if _, isConst := variable.Value.(scope.Constant); isConst {
+		p.asm.Err(id, "%v is a constant, it can't be changed or passed by reference", id.Id)
+		return asm.NullPointer, false
+	}
+
+	if arr, isArray := variable.Value.(*array); isArray {
+		id.Index = p.substitute(id.Index)
+		return p.element(id, arr)
+	}
+
+	if id.Index != nil {
+		p.asm.Err(id, "%v is not an array", id.Id)
+		return asm.NullPointer, false
+	}
+
 	pt, ok := variable.Value.(int)
 	if !ok {
 		p.asm.Err(id, "Expected pointer, got %v", reflect.TypeOf(variable.Value))
@@ -31,27 +80,286 @@ func (p *program) GetPt(id parse.Ident) (asm.Pointer, bool) {
 	return asm.Pointer(pt), true
 }
 
+// constant is the value of id, if it names a constant
+func (p *program) constant(id parse.Ident) (int, bool) {
+	if id.Index != nil {
+		return 0, false
+	}
+
+	variable, ok := p.sc.Get(id.Id)
+	if !ok {
+		return 0, false
+	}
+
+	c, isConst := variable.Value.(scope.Constant)
+	return int(c), isConst
+}
+
+// resolve is substitute, also checking that every value in expr fits in a
+// cell. Only use it where the values end up in cells.
+func (p *program) resolve(expr parse.Expr) parse.Expr {
+	expr = p.substitute(expr)
+	p.checkRange(expr, false)
+	return expr
+}
+
+// substitute replaces every constant in expr with its value
+func (p *program) substitute(expr parse.Expr) parse.Expr {
+	switch e := expr.(type) {
+	case parse.Ident:
+		if c, isConst := p.constant(e); isConst {
+			return parse.Lit{Val: c, Pos: e.Pos}
+		}
+		if e.Index != nil {
+			e.Index = p.substitute(e.Index)
+		}
+		return e
+	case parse.Arith:
+		e.Lhs, e.Rhs = p.substitute(e.Lhs), p.substitute(e.Rhs)
+		return e
+	case parse.Comparison:
+		e.Lhs, e.Rhs = p.substitute(e.Lhs), p.substitute(e.Rhs)
+		return e
+	case parse.Logical:
+		e.Lhs, e.Rhs = p.substitute(e.Lhs), p.substitute(e.Rhs)
+		return e
+	case parse.Not:
+		e.Expr = p.substitute(e.Expr)
+		return e
+	default:
+		return expr
+	}
+}
+
+// checkRange reports every literal in expr that doesn't fit in a cell. Only
+// a literal that is being set or added onto cells can be negative, when it
+// subtracts, and then it can go as far the other way.
+func (p *program) checkRange(expr parse.Expr, negative bool) {
+	switch e := expr.(type) {
+	case parse.Lit:
+		switch {
+		case e.Val < 0 && !negative:
+			p.asm.Err(e, "%d is negative, which only means something when setting, adding or subtracting", e.Val)
+		case abs(e.Val) > p.maxValue():
+			p.asm.Err(e, "%d doesn't fit in a cell of %d bits", e.Val, p.opts.CellBits)
+		}
+	case parse.Arith:
+		p.checkRange(e.Lhs, false)
+		p.checkRange(e.Rhs, false)
+	case parse.Comparison:
+		p.checkRange(e.Lhs, false)
+		p.checkRange(e.Rhs, false)
+	case parse.Logical:
+		p.checkRange(e.Lhs, false)
+		p.checkRange(e.Rhs, false)
+	case parse.Not:
+		p.checkRange(e.Expr, false)
+	}
+}
+
+// setsOrAdds is whether every identifier is being set, added or subtracted
+// onto, rather than scaled
+func setsOrAdds(idents []parse.Ident) bool {
+	for _, id := range idents {
+		if id.Op != parse.None && id.Op != parse.Add && id.Op != parse.Sub {
+			return false
+		}
+	}
+
+	return true
+}
+
+// resolveIndexes resolves the indexes of the identifiers, which must stay
+// identifiers as they are being assigned to
+func (p *program) resolveIndexes(idents []parse.Ident) []parse.Ident {
+	resolved := make([]parse.Ident, len(idents))
+	for i, id := range idents {
+		if id.Index != nil {
+			id.Index = p.substitute(id.Index)
+		}
+		resolved[i] = id
+	}
+
+	return resolved
+}
+
+// DefPt allocates a cell for id which is released when the current scope exits
 func (p *program) DefPt(id *string, near int) (asm.Pointer, error) {
-	pt := p.mem.Malloc(near)
-	_, err := p.sc.Define(id, pt)
+	pt, err := p.mem.Malloc(near)
+	if err != nil {
+		return asm.NullPointer, err
+	}
+
+	if _, err = p.sc.Define(id, pt); err != nil {
+		p.mem.Free(pt)
+		return asm.NullPointer, err
+	}
 
-	return asm.Pointer(pt), err
+	top := len(p.locals) - 1
+	p.locals[top] = append(p.locals[top], asm.Pointer(pt))
+	return asm.Pointer(pt), nil
+}
+
+// outOfMemory reports running out of memory, only the first time for each
+// statement as everything after it is likely to run out too
+func (p *program) outOfMemory(subject parse.Expr) {
+	if !p.full {
+		p.asm.Err(subject, "Ran out of memory, the tape is limited to %d cells", p.mem.Limit())
+		p.full = true
+	}
+}
+
+// Temp allocates a scratch cell. It must be zero again by the time it is freed.
+func (p *program) Temp(near asm.Pointer) asm.Pointer {
+	pt, err := p.mem.Malloc(int(near))
+	if err != nil {
+		p.outOfMemory(p.stmt)
+		return asm.NullPointer
+	}
+
+	return asm.Pointer(pt)
+}
+
+func (p *program) Free(pt asm.Pointer) {
+	if pt != asm.NullPointer {
+		p.mem.Free(int(pt))
+	}
+}
+
+// Drain empties from, adding its value times the delta onto each cell in deltas
+func (p *program) Drain(from asm.Pointer, deltas map[asm.Pointer]int) {
+	targets := make([]int, 0, len(deltas))
+	for pt := range deltas {
+		targets = append(targets, int(pt))
+	}
+	sort.Ints(targets)
+
+	p.asm.OpenLoop(from)
+	p.asm.Add(from, -1)
+	for _, pt := range targets {
+		if n := deltas[asm.Pointer(pt)]; n != 0 {
+			p.asm.Add(asm.Pointer(pt), n)
+		}
+	}
+	p.asm.CloseLoop()
+}
+
+// Move adds the value of from onto to, leaving from at zero
+func (p *program) Move(from, to asm.Pointer) {
+	p.Drain(from, map[asm.Pointer]int{to: 1})
+}
+
+// Copy adds the value of from onto to, leaving from as it was
+func (p *program) Copy(from, to asm.Pointer) {
+	temp := p.Temp(from)
+	p.Drain(from, map[asm.Pointer]int{to: 1, temp: 1})
+	p.Move(temp, from)
+	p.Free(temp)
+}
+
+func (p *program) Clear(pt asm.Pointer) {
+	p.asm.OpenLoop(pt)
+	p.asm.Add(pt, -1)
+	p.asm.CloseLoop()
 }
 
 func (p *program) EnterScope() {
 	p.sc = p.sc.Enter()
+	p.locals = append(p.locals, nil)
+	p.reserved = append(p.reserved, nil)
 }
 
+// ExitScope zeroes and frees the cells declared in the scope. Clearing them
+// keeps the invariant that free cells are zero, which a loop body running its
+// declarations a second time relies on.
 func (p *program) ExitScope() {
+	top := len(p.locals) - 1
+	for _, pt := range p.locals[top] {
+		p.Clear(pt)
+		p.Free(pt)
+	}
+	for _, pt := range p.reserved[top] {
+		p.Free(pt)
+	}
+
+	p.locals = p.locals[:top]
+	p.reserved = p.reserved[:top]
 	p.sc = p.sc.Exit()
 }
 
 func compileVarDef(p *program, expr parse.VarDef) {
+	expr.Idents = p.resolveIndexes(expr.Idents)
 	for _, ident := range expr.Idents {
-		if _, exists := p.DefPt(&ident.Id, -1); exists != nil {
+		if ident.Index != nil {
+			if expr.Rhs != nil {
+				p.asm.Err(ident, "Arrays cannot be given a value when they are declared")
+				return
+			}
+			defArray(p, ident)
+			continue
+		}
+
+		switch _, err := p.DefPt(&ident.Id, -1); err {
+		case nil:
+		case scope.ErrAlreadyDefined:
 			p.asm.Err(ident, "Cannot redefine variable within the same scope")
+		default:
+			p.outOfMemory(ident)
+			return
 		}
 	}
+
+	if expr.Rhs != nil {
+		p.asm.Comment(expr.String())
+		assign(p, expr.Idents, expr.Rhs, true)
+	}
+}
+
+func compileConstDef(p *program, expr parse.ConstDef) {
+	val, ok := constValue(p, p.substitute(expr.Rhs))
+	if !ok {
+		return
+	}
+
+	for _, ident := range expr.Idents {
+		if ident.Op != parse.None || ident.Index != nil {
+			p.asm.Err(ident, "A constant can only be given a plain name")
+			continue
+		}
+
+		if _, err := p.sc.Define(&ident.Id, scope.Constant(val)); err != nil {
+			p.asm.Err(ident, "Cannot redefine variable within the same scope")
+		}
+	}
+}
+
+// constValue works out a resolved expression made only of literals
+func constValue(p *program, expr parse.Expr) (int, bool) {
+	switch e := expr.(type) {
+	case parse.Lit:
+		return e.Val, true
+	case parse.Ident:
+		p.asm.Err(e, "%v is not a constant", e.Id)
+	case parse.Arith:
+		lhs, lhsOk := constValue(p, e.Lhs)
+		rhs, rhsOk := constValue(p, e.Rhs)
+		if !lhsOk || !rhsOk {
+			return 0, false
+		}
+
+		switch {
+		case e.Op == parse.Multiply:
+			return lhs * rhs, true
+		case rhs == 0:
+			p.asm.Err(p.stmt, "Division by zero")
+		case e.Op == parse.Divide:
+			return lhs / rhs, true
+		case e.Op == parse.Modulo:
+			return lhs % rhs, true
+		}
+	}
+
+	return 0, false
 }
 
 type ptIdentWrapper struct {
@@ -83,95 +391,699 @@ func getAndSort(p *program, idents []parse.Ident) []ptIdentWrapper {
 
 func compileAssignment(p *program, expr parse.Assignment) {
 	p.asm.Comment(expr.String())
+	assign(p, expr.Lhs, expr.Rhs, false)
+}
+
+// assign sets, adds, subtracts or multiplies every identifier in lhs by rhs.
+// When fresh is set the lhs cells are known to be zero so they don't need
+// clearing before a set. A division into exactly two identifiers assigns the
+// quotient to the first and the remainder to the second.
+func assign(p *program, lhsIdents []parse.Ident, rhsExpr parse.Expr, fresh bool) {
+	lhsIdents, rhsExpr = p.resolveIndexes(lhsIdents), p.substitute(rhsExpr)
+	p.checkRange(rhsExpr, setsOrAdds(lhsIdents))
+	for _, v := range lhsIdents {
+		if arr, isElement := dynamicElement(p, v); isElement {
+			if len(lhsIdents) > 1 {
+				p.asm.Err(v, "An array element indexed by a variable must be assigned on its own")
+				return
+			}
+
+			compileArrayWrite(p, v, arr, rhsExpr)
+			return
+		}
+	}
+
+	lhs := getAndSort(p, lhsIdents)
+	for _, v := range lhs {
+		if v.pt == asm.NullPointer {
+			return
+		}
+	}
 
-	var rhs asm.Pointer
-	switch val := expr.Rhs.(type) {
+	var src asm.Pointer
+	floored, owned := false, false
+
+	switch val := rhsExpr.(type) {
 	case parse.Lit:
-		rhs, _ = p.DefPt(nil, -1)
-		p.asm.Add(rhs, val.Val)
+		summed := make([]parse.Ident, 0, len(lhsIdents))
+		for _, v := range lhsIdents {
+			if v.Op == parse.Mul {
+				pt, _ := p.GetPt(v)
+				p.Scale(pt, val.Val)
+			} else {
+				summed = append(summed, v)
+			}
+		}
+
+		if len(summed) == 1 {
+			setConst(p, summed[0], val.Val, fresh)
+		} else if len(summed) > 0 {
+			temp := p.Temp(lhs[0].pt)
+			p.AddConst(temp, val.Val)
+			distribute(p, summed, temp, true, fresh)
+			p.Free(temp)
+		}
+		return
 	case parse.Ident:
-		rhs, _ = p.GetPt(val)
+		if arr, isElement := dynamicElement(p, val); isElement {
+			if src = compileArrayRead(p, val, arr); src == asm.NullPointer {
+				return
+			}
+
+			// Scaling by the element leaves it in the carry cell, which must be zeroed
+			assignFrom(p, lhsIdents, src, true, false, fresh)
+			p.Clear(src)
+			return
+		}
+
+		var ok bool
+		if src, ok = p.GetPt(val); !ok {
+			return
+		}
+		floored = val.Op == parse.Floor
+	case parse.Arith:
+		if val.Op == parse.Divide && len(lhsIdents) == 2 {
+			quotient, remainder := compileDivMod(p, val.Lhs, val.Rhs, lhs[0].pt, true)
+			if quotient == asm.NullPointer {
+				return
+			}
+			assignFrom(p, lhsIdents[:1], quotient, true, true, fresh)
+			assignFrom(p, lhsIdents[1:], remainder, true, true, fresh)
+			return
+		}
+
+		if src = compileArith(p, val, lhs[0].pt); src == asm.NullPointer {
+			return
+		}
+		floored, owned = true, true
 	}
 
-	lhs := getAndSort(p, expr.Lhs)
-	for _, v := range lhs {
-		if v.id.Op == parse.None {
-			p.asm.OpenLoop(v.pt)
-			p.asm.Add(v.pt, -1)
-			p.asm.CloseLoop()
+	assignFrom(p, lhsIdents, src, floored, owned, fresh)
+}
+
+// setConst assigns a literal straight onto a single identifier
+func setConst(p *program, id parse.Ident, n int, fresh bool) {
+	pt, _ := p.GetPt(id)
+	switch id.Op {
+	case parse.None:
+		if !fresh {
+			p.Clear(pt)
 		}
+		p.AddConst(pt, n)
+	case parse.Add:
+		p.AddConst(pt, n)
+	case parse.Sub:
+		p.AddConst(pt, -n)
+	default:
+		p.asm.Err(id, "Invalid operator")
 	}
+}
 
-	p.asm.OpenLoop(rhs)
-	p.asm.Add(rhs, -1)
+// assignFrom is assign with a cell on the right hand side. An owned src is a
+// scratch cell which is zeroed and freed afterwards.
+func assignFrom(p *program, lhsIdents []parse.Ident, src asm.Pointer, floored, owned, fresh bool) {
+	scaled := make([]parse.Ident, 0, len(lhsIdents))
+	summed := make([]parse.Ident, 0, len(lhsIdents))
+	for _, v := range lhsIdents {
+		if v.Op == parse.Mul {
+			scaled = append(scaled, v)
+		} else {
+			summed = append(summed, v)
+		}
+	}
 
-	for _, v := range lhs {
-		switch v.id.Op {
-		case parse.Add, parse.None:
-			p.asm.Add(v.pt, 1)
+	if len(scaled) > 0 {
+		if isAssignedTo(getAndSort(p, scaled), src) {
+			copied := p.Temp(src)
+			p.Copy(src, copied)
+			src, floored, owned = copied, true, true
+		}
+
+		for _, v := range scaled {
+			pt, _ := p.GetPt(v)
+			p.ScaleBy(pt, src)
+		}
+	}
+
+	if len(summed) > 0 {
+		distribute(p, summed, src, floored, fresh)
+	} else if owned {
+		p.Clear(src)
+	}
+
+	if owned {
+		p.Free(src)
+	}
+}
+
+// distribute adds the value of src to every identifier in lhs, or sets them
+// to it. The src cell is drained one at a time, so a source that must survive
+// (not floored) or that is also assigned to is first moved into a scratch cell
+// and rebuilt from there as part of the same loop.
+func distribute(p *program, lhsIdents []parse.Ident, src asm.Pointer, floored bool, fresh bool) {
+	lhs := getAndSort(p, lhsIdents)
+	source, scratch := asm.NullPointer, asm.NullPointer
+	deltas := make(map[asm.Pointer]int)
+
+	if !floored || isAssignedTo(lhs, src) {
+		source = src
+		if !floored {
+			deltas[source] = 1
+		}
+	}
+
+	for _, v := range lhsIdents {
+		pt, _ := p.GetPt(v)
+		switch v.Op {
+		case parse.None:
+			deltas[pt] = 1
+		case parse.Add:
+			deltas[pt]++
 		case parse.Sub:
-			p.asm.Add(v.pt, -1)
+			deltas[pt]--
 		default:
-			p.asm.Err(v.id, "Invalid operator")
+			p.asm.Err(v, "Invalid operator")
 		}
 	}
 
-	p.asm.CloseLoop()
+	for i, v := range lhs {
+		cleared := i > 0 && lhs[i-1].pt == v.pt
+		if v.id.Op == parse.None && !fresh && v.pt != source && !cleared {
+			p.Clear(v.pt)
+		}
+	}
+
+	if source != asm.NullPointer {
+		scratch = p.Temp(source)
+		p.Move(source, scratch)
+		src = scratch
+	}
+
+	p.Drain(src, deltas)
+	p.Free(scratch)
 }
 
+func isAssignedTo(lhs []ptIdentWrapper, pt asm.Pointer) bool {
+	for _, v := range lhs {
+		if v.pt == pt {
+			return true
+		}
+	}
+
+	return false
+}
+
+// compilePrintStmt prints the variables directly. Runs of literals and strings
+// are printed together from a few scratch cells.
 func compilePrintStmt(p *program, expr parse.PrintStmt) {
+	if expr.Decimal {
+		compileDecimalPrint(p, expr)
+		return
+	}
+
+	var constant []byte
+	for _, arg := range expr.Args {
+		switch arg := p.resolve(arg).(type) {
+		case parse.Lit:
+			constant = append(constant, byte(arg.Val))
+		case parse.Str:
+			constant = append(constant, arg.Val...)
+		case parse.Ident:
+			printConstant(p, constant)
+			constant = constant[:0]
+
+			if arg.Op != parse.None {
+				p.asm.Err(arg, "Unexpected operator in print statement")
+			}
+
+			if pt, ok := p.GetPt(arg); ok {
+				p.asm.Print(pt)
+			}
+		}
+	}
+
+	printConstant(p, constant)
+}
+
+// maxPrintCells is how many scratch cells printConstant may spread its
+// values over
+const maxPrintCells = 3
+
+// printConstant prints each byte from whichever scratch cell is cheapest to
+// get to it from, starting a new cell from zero if that is cheaper still. The values are known
+// all the way through, so the cells are put back to zero without any loops.
+func printConstant(p *program, bytes []byte) {
+	type cell struct {
+		pt  asm.Pointer
+		val int
+	}
+
+	var cells []cell
+	for _, b := range bytes {
+		best, bestCost := -1, p.constCost(int(b))
+		if len(cells) == maxPrintCells {
+			bestCost = math.MaxInt32
+		}
+
+		for i, c := range cells {
+			if cost := p.constCost(int(b) - c.val); cost < bestCost {
+				best, bestCost = i, cost
+			}
+		}
+
+		if best < 0 {
+			near := asm.NullPointer
+			if len(cells) > 0 {
+				near = cells[len(cells)-1].pt
+			}
+			cells = append(cells, cell{pt: p.Temp(near)})
+			best = len(cells) - 1
+		}
+
+		c := &cells[best]
+		p.AddConst(c.pt, int(b)-c.val)
+		c.val = int(b)
+		p.asm.Print(c.pt)
+	}
+
+	for _, c := range cells {
+		p.AddConst(c.pt, -c.val)
+		p.Free(c.pt)
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func compileReadStmt(p *program, expr parse.ReadStmt) {
 	for _, v := range expr.Idents {
 		if v.Op != parse.None {
-			p.asm.Err(v, "Unexpected operator in print statement")
+			p.asm.Err(v, "Unexpected operator in read statement")
 		}
 
-		pt, ok := p.GetPt(v)
-		if ok {
-			p.asm.Print(pt)
+		if expr.Decimal {
+			readDecimal(p, v)
+		} else if pt, ok := p.GetPt(v); ok {
+			p.asm.Read(pt)
 		}
 	}
 }
 
+// compileWhileStmt loops until the subject is zero. A -$subject is decremented
+// at the end of every iteration and restored once the loop is done, whereas
+// _$subject is decremented and left at zero.
 func compileWhileStmt(p *program, expr parse.WhileStmt) {
-	pt, subjectExists := p.GetPt(expr.Subject)
-	p.asm.OpenLoop(pt)
-	defer p.asm.CloseLoop()
+	if jumps(expr.Body) {
+		compileBreakableWhile(p, expr)
+		return
+	}
 
-	p.EnterScope()
-	defer p.ExitScope()
+	if subject, isIdent := expr.Subject.(parse.Ident); isIdent {
+		if n, isConst := p.constant(subject); isConst && subject.Op != parse.None {
+			compileCountedWhile(p, expr, n)
+			return
+		}
+	}
+
+	expr.Subject = p.resolve(expr.Subject)
+	subject, isIdent := expr.Subject.(parse.Ident)
+	if !isIdent {
+		compileConditionalWhile(p, expr)
+		return
+	}
 
-	compileStmtCollection(p, expr.Body)
+	pt, subjectExists := p.GetPt(subject)
+
+	counter := asm.NullPointer
+	if subjectExists && subject.Op == parse.Sub {
+		counter = p.Temp(pt)
+	}
+
+	p.asm.OpenLoop(pt)
+	compileScopedBody(p, expr.Body)
 
 	if subjectExists {
-		switch expr.Subject.Op {
+		switch subject.Op {
 		case parse.Add:
 			p.asm.Add(pt, 1)
-		case parse.Sub, parse.Floor:
+		case parse.Sub:
+			p.asm.Add(pt, -1)
+			p.asm.Add(counter, 1)
+		case parse.Floor:
 			p.asm.Add(pt, -1)
 		}
 	}
+	p.asm.CloseLoop()
+
+	if counter != asm.NullPointer {
+		p.Move(counter, pt)
+		p.Free(counter)
+	}
+}
+
+// compileCountedWhile runs the body a constant number of times, as counting
+// down a constant leaves it where it was
+func compileCountedWhile(p *program, expr parse.WhileStmt, n int) {
+	subject := expr.Subject.(parse.Ident)
+	if subject.Op != parse.Sub && subject.Op != parse.Floor {
+		p.asm.Err(subject, "%v is a constant, it can't be changed", subject.Id)
+		return
+	}
+
+	counter := p.Temp(asm.NullPointer)
+	p.AddConst(counter, n)
+	p.asm.OpenLoop(counter)
+	compileScopedBody(p, expr.Body)
+	p.asm.Add(counter, -1)
+	p.asm.CloseLoop()
+	p.Free(counter)
+}
+
+// compileRepeatStmt records the body once, then replays it both unrolled and
+// inside a loop over a counter, keeping whichever is shorter
+func compileRepeatStmt(p *program, expr parse.RepeatStmt) {
+	count, isLit := p.substitute(expr.Count).(parse.Lit)
+	if !isLit || count.Val < 0 {
+		p.asm.Err(p.stmt, "Can only repeat a literal or constant number of times")
+		return
+	}
+
+	// The counters are taken before the body so it can't use the same cells
+	counters := make([]asm.Pointer, repeatDepth(count.Val, p.maxValue()))
+	for i := range counters {
+		counters[i] = p.Temp(asm.NullPointer)
+	}
+	defer func() {
+		for _, counter := range counters {
+			p.Free(counter)
+		}
+	}()
+
+	out := p.asm
+	defer func() { p.asm = out }()
+
+	body := asm.NewRecorder()
+	p.asm = body
+	if len(p.loops) > 0 && jumps(expr.Body) {
+		// After a break or continue the rest of the times around do nothing
+		guard(p, func() { compileScopedBody(p, expr.Body) })
+	} else {
+		compileScopedBody(p, expr.Body)
+	}
+
+	// Errors in the body are only reported once
+	if body.Failed() {
+		body.Replay(out, nil)
+		return
+	}
+
+	replayBody := func() { body.Replay(p.asm, nil) }
+
+	loop := asm.NewRecorder()
+	p.asm = loop
+	repeatLoop(p, count.Val, counters, replayBody)
+
+	unrolled := asm.NewRecorder()
+	p.asm = unrolled
+	for i := 0; i < count.Val && unrolled.Len() <= loop.Len(); i++ {
+		replayBody()
+	}
+
+	if unrolled.Len() <= loop.Len() {
+		unrolled.Replay(out, nil)
+	} else {
+		loop.Replay(out, nil)
+	}
+}
+
+// repeatDepth is the number of counters repeatLoop needs to go around n times
+func repeatDepth(n int, max int) int {
+	switch {
+	case n <= 1:
+		return 0
+	case n > max:
+		return repeatDepth(n/max, max) + 1
+	default:
+		return 1
+	}
+}
+
+// repeatLoop runs the code from compile n times by counting down the first
+// of the counters. Counts too big for a cell are split across nested
+// counters, with another loop for whatever is left over.
+func repeatLoop(p *program, n int, counters []asm.Pointer, compile func()) {
+	max := p.maxValue()
+	switch {
+	case n == 0:
+	case n == 1:
+		compile()
+	case n > max:
+		inner := counters[repeatDepth(n/max, max):]
+		repeatLoop(p, n/max, counters, func() { repeatLoop(p, max, inner, compile) })
+		repeatLoop(p, n%max, counters, compile)
+	default:
+		counter := counters[0]
+		p.AddConst(counter, n)
+		p.asm.OpenLoop(counter)
+		compile()
+		p.asm.Add(counter, -1)
+		p.asm.CloseLoop()
+	}
+}
+
+// compileConditionalWhile works out the condition into a flag before every
+// time around the loop, including the first.
+func compileConditionalWhile(p *program, expr parse.WhileStmt) {
+	flag := p.Temp(conditionNear(p, expr.Subject))
+	compileCondition(p, expr.Subject, flag)
+
+	p.asm.OpenLoop(flag)
+	p.asm.Add(flag, -1)
+	compileScopedBody(p, expr.Body)
+	compileCondition(p, expr.Subject, flag)
+	p.asm.CloseLoop()
+
+	p.Free(flag)
+}
+
+func compileScopedBody(p *program, body parse.StmtCollection) {
+	p.EnterScope()
+	defer p.ExitScope()
+
+	compileStmtCollection(p, body)
+}
+
+// compileIfStmt runs the body at most once. A floored subject is used as the
+// loop cell directly and zeroed by the body, otherwise the subject is moved
+// into a temporary which is moved back as soon as the branch is taken. Any
+// other condition is worked out into a flag first.
+func compileIfStmt(p *program, expr parse.IfStmt) {
+	expr.Subject = p.resolve(expr.Subject)
+	subject, isIdent := expr.Subject.(parse.Ident)
+
+	pt := asm.NullPointer
+	if isIdent {
+		var ok bool
+		if pt, ok = p.GetPt(subject); !ok {
+			return
+		}
+	} else {
+		pt = p.Temp(conditionNear(p, expr.Subject))
+		compileCondition(p, expr.Subject, pt)
+	}
+
+	elseFlag := asm.NullPointer
+	if expr.Else != nil {
+		elseFlag = p.Temp(pt)
+		p.asm.Add(elseFlag, 1)
+	}
+
+	switch {
+	case !isIdent:
+		p.asm.OpenLoop(pt)
+		p.asm.Add(pt, -1)
+		if elseFlag != asm.NullPointer {
+			p.asm.Add(elseFlag, -1)
+		}
+		compileScopedBody(p, expr.Body)
+		p.asm.CloseLoop()
+		p.Free(pt)
+	case subject.Op == parse.Floor:
+		p.asm.OpenLoop(pt)
+		if elseFlag != asm.NullPointer {
+			p.asm.Add(elseFlag, -1)
+		}
+		compileScopedBody(p, expr.Body)
+		p.Clear(pt)
+		p.asm.CloseLoop()
+	case subject.Op == parse.None:
+		temp := p.Temp(pt)
+		p.Move(pt, temp)
+
+		p.asm.OpenLoop(temp)
+		p.Move(temp, pt)
+		if elseFlag != asm.NullPointer {
+			p.asm.Add(elseFlag, -1)
+		}
+		compileScopedBody(p, expr.Body)
+		p.asm.CloseLoop()
+		p.Free(temp)
+	default:
+		p.asm.Err(subject, "Unexpected operator in if statement")
+		return
+	}
+
+	if elseFlag != asm.NullPointer {
+		p.asm.OpenLoop(elseFlag)
+		p.asm.Add(elseFlag, -1)
+		compileScopedBody(p, expr.Else)
+		p.asm.CloseLoop()
+		p.Free(elseFlag)
+	}
 }
 
 func compileFuncDec(p *program, expr parse.FuncDec) {
-	p.asm.Err(expr, "Functions are not implemented... yet")
+	if _, err := p.sc.Define(&expr.Name.Id, &function{dec: expr, sc: p.sc}); err != nil {
+		p.asm.Err(expr.Name, "Cannot redefine %v within the same scope", expr.Name.Id)
+	}
+}
+
+// isLiteralParam is whether the parameter is bound to a literal rather than a
+// variable
+func isLiteralParam(param parse.Ident) bool {
+	return strings.HasPrefix(param.Id, "#")
+}
+
+// compileFuncCall inlines the body of the function, binding each parameter to
+// whatever the argument refers to in the caller (a cell or another function).
+func compileFuncCall(p *program, expr parse.FuncCall) {
+	variable, ok := p.sc.Get(expr.Func.Id)
+	if !ok {
+		p.asm.Err(expr.Func, "%v is not defined", expr.Func.Id)
+		return
+	}
+
+	fn, ok := variable.Value.(*function)
+	if !ok {
+		p.asm.Err(expr.Func, "%v is not a function", expr.Func.Id)
+		return
+	}
+
+	if len(expr.Args) != len(fn.dec.Args) {
+		p.asm.Err(expr, "%v expects %d arguments, got %d", expr.Func.Id, len(fn.dec.Args), len(expr.Args))
+		return
+	}
+
+	if fn.inlining {
+		p.asm.Err(expr, "%v cannot be inlined into itself", expr.Func.Id)
+		return
+	}
+
+	values := make([]interface{}, len(expr.Args))
+	for i, arg := range expr.Args {
+		param := fn.dec.Args[i]
+		if isLiteralParam(param) {
+			lit, isLit := p.substitute(arg).(parse.Lit)
+			if !isLit {
+				p.asm.Err(expr, "%v of %v takes a literal or constant, not %v", param.Id, expr.Func.Id, arg)
+				return
+			}
+			if lit.Val < 0 {
+				p.asm.Err(expr, "%v of %v can't be negative", param.Id, expr.Func.Id)
+				return
+			}
+			values[i] = scope.Constant(lit.Val)
+			continue
+		}
+
+		arg, isIdent := arg.(parse.Ident)
+		if !isIdent {
+			p.asm.Err(expr, "%v of %v is passed by reference, it needs a variable", param.Id, expr.Func.Id)
+			return
+		}
+
+		if arg.Op != parse.None {
+			p.asm.Err(arg, "Unexpected operator in function argument")
+			return
+		}
+
+		if arg.Index != nil {
+			pt, ok := p.GetPt(arg)
+			if !ok {
+				return
+			}
+			values[i] = int(pt)
+			continue
+		}
+
+		v, ok := p.sc.Get(arg.Id)
+		if !ok {
+			p.asm.Err(arg, "%v is not defined", arg.Id)
+			return
+		}
+
+		if _, isConst := v.Value.(scope.Constant); isConst {
+			p.asm.Err(arg, "%v is a constant, it can't be passed by reference", arg.Id)
+			return
+		}
+		values[i] = v.Value
+	}
+
+	caller, loops := p.sc, p.loops
+	p.sc, p.loops = fn.sc, nil
+	p.EnterScope()
+	fn.inlining = true
+
+	for i, param := range fn.dec.Args {
+		if _, err := p.sc.Define(&param.Id, values[i]); err != nil {
+			p.asm.Err(param, "Parameter %v is declared twice", param.Id)
+		}
+	}
+	compileStmtCollection(p, fn.dec.Body)
+
+	fn.inlining = false
+	p.ExitScope()
+	p.sc, p.loops = caller, loops
 }
 
 func compileSyntaxError(p *program, expr parse.SyntaxError) {
-	p.asm.Err(expr, expr.String())
+	p.asm.Err(expr, "%s", expr.String())
 }
 
 func compileStmt(p *program, expr parse.Stmt) {
+	outer, full := p.stmt, p.full
+	p.stmt, p.full = expr, false
+	defer func() { p.stmt, p.full = outer, full }()
+
 	switch val := expr.Expr.(type) {
 	case parse.VarDef:
 		compileVarDef(p, val)
+	case parse.ConstDef:
+		compileConstDef(p, val)
 	case parse.Assignment:
 		compileAssignment(p, val)
 	case parse.PrintStmt:
 		compilePrintStmt(p, val)
+	case parse.ReadStmt:
+		compileReadStmt(p, val)
+	case parse.IfStmt:
+		compileIfStmt(p, val)
 	case parse.WhileStmt:
 		compileWhileStmt(p, val)
+	case parse.RepeatStmt:
+		compileRepeatStmt(p, val)
+	case parse.BreakStmt, parse.ContinueStmt:
+		compileJump(p, val)
+	case parse.ImportStmt:
+		p.asm.Err(expr, "Files can only be imported outside of any block")
 	case parse.FuncDec:
 		compileFuncDec(p, val)
+	case parse.FuncCall:
+		compileFuncCall(p, val)
 	case parse.SyntaxError:
 		compileSyntaxError(p, val)
 	case parse.Stmt:
@@ -184,11 +1096,26 @@ func compileStmt(p *program, expr parse.Stmt) {
 }
 
 func compileStmtCollection(p *program, stmts parse.StmtCollection) {
-	for _, stmt := range stmts {
+	for i, stmt := range stmts {
 		compileStmt(p, stmt)
+
+		// Everything after a break or continue only runs if it didn't happen
+		if len(p.loops) > 0 && i+1 < len(stmts) && jumps(stmt) {
+			guard(p, func() { compileStmtCollection(p, stmts[i+1:]) })
+			return
+		}
 	}
 }
 
-func Compile(a asm.Assembler, stmts parse.StmtCollection) {
-	compileStmtCollection(&program{scope.New(), memory.New(), a}, stmts)
+func Compile(a asm.Assembler, stmts parse.StmtCollection, opts Options) {
+	p := &program{
+		opts:     opts,
+		sc:       scope.New(),
+		mem:      memory.New(opts.Cells),
+		asm:      a,
+		locals:   make([][]asm.Pointer, 1),
+		reserved: make([][]asm.Pointer, 1),
+	}
+
+	compileStmtCollection(p, stmts)
 }
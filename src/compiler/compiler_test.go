@@ -0,0 +1,706 @@
+package compiler_test
+
+import (
+	"asm"
+	"bytes"
+	"compiler"
+	"fmt"
+	"interp"
+	"loader"
+	"parse"
+	"strings"
+	"testing"
+)
+
+// stripComments drops the comment lines the compiler interleaves with the code
+func stripComments(bf string) string {
+	lines := strings.Split(bf, "\n")
+	code := lines[:0]
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "# ") {
+			code = append(code, line)
+		}
+	}
+
+	return strings.Join(code, "")
+}
+
+func expectBf(t *testing.T, expected string, source string) {
+	stmts, diags := parse.Parse(parse.Lex(source))
+	if diags.HasErrors() {
+		t.Fatalf("Unexpected parse errors:\n%v", diags)
+	}
+
+	assembler, ch := asm.New()
+	go func() {
+		compiler.Compile(assembler, stmts, compiler.DefaultOptions)
+		close(ch)
+	}()
+
+	result := ""
+	for node := range ch {
+		result += node.ToBF()
+	}
+
+	if result = stripComments(result); result != expected {
+		t.Errorf("\nSource:\t%v\nExpect:\t%v\nActual:\t%v", source, expected, result)
+	}
+}
+
+// expectOutput compiles source and runs it against input
+func expectOutput(t *testing.T, expected string, source string, input string) {
+	out, _ := run(t, source, input, compiler.DefaultOptions, interp.DefaultConfig)
+	if out != expected {
+		t.Errorf("\nSource:\t%v\nExpect:\t%q\nActual:\t%q", source, expected, out)
+	}
+}
+
+// run compiles and optimises source along with anything it imports, then runs
+// it. It returns the output and the brainfuck that was run.
+func run(t *testing.T, source string, input string, opts compiler.Options, cfg interp.Config) (string, string) {
+	stmts, diags := loader.New(nil).Load("test.bfu", []byte(source))
+	if diags.HasErrors() {
+		t.Fatalf("Unexpected parse errors:\n%v", diags)
+	}
+
+	assembler, ch := asm.New()
+	go func() {
+		compiler.Compile(assembler, stmts, opts)
+		close(ch)
+	}()
+
+	bf := strings.Builder{}
+	for node := range asm.Optimise(ch) {
+		if d, isErr := asm.Diagnostic(node); isErr {
+			t.Fatalf("Unexpected error compiling %v: %v", source, d)
+		}
+		bf.WriteString(node.ToBF())
+	}
+
+	out := &bytes.Buffer{}
+	if err := interp.Run(bf.String(), strings.NewReader(input), out, cfg); err != nil {
+		t.Fatalf("Unexpected error running %v: %v", source, err)
+	}
+
+	return out.String(), bf.String()
+}
+
+func TestIfFloored(t *testing.T) {
+	expectBf(t, "[>.<[-]]", "var $a, $b; if _$a { print $b; }")
+}
+
+func TestIfPreservesSubject(t *testing.T) {
+	expectBf(t, "[->>+<<]>>[[-<<+>>]<.>]", "var $a, $b; if $a { print $b; }")
+}
+
+func TestIfElse(t *testing.T) {
+	expectBf(t, ">>+<<[>>-<.<[-]]>>[-<<.>>]",
+		"var $a, $b; if _$a { print $b; } else { print $a; }")
+}
+
+func TestFuncCallBindsByReference(t *testing.T) {
+	expectBf(t, ">.<.", "var $a, $b; def $show($x, $y) { print $y, $x; } $show($a, $b);")
+}
+
+func TestFuncAsArgument(t *testing.T) {
+	expectBf(t, ">..", `
+		var $a, $b;
+		def $show($x) { print $x; }
+		def $twice($f, $v) { $f($v); $f($v); }
+		$twice($show, $b);`)
+}
+
+func TestRead(t *testing.T) {
+	expectBf(t, ">,<,>.", "var $a, $b; read $b, $a; print $b;")
+}
+
+func TestVarDefWithValue(t *testing.T) {
+	expectBf(t, ">>++[-<<+>+>]<.", "var $a, $b = 2; print $b;")
+}
+
+func TestAssignmentPreservesSource(t *testing.T) {
+	expectBf(t, "[-]>[->+<]>[-<<+>+>]", "var $a, $b; $a = $b;")
+}
+
+func TestAssignmentFlooredSource(t *testing.T) {
+	expectBf(t, "[-]>[-<+>]", "var $a, $b; $a = _$b;")
+}
+
+func TestWhileRestoresSubject(t *testing.T) {
+	expectBf(t, "[>.<->>+<<]>>[-<<+>>]", "var $n, $c; while -$n { print $c; }")
+	expectBf(t, "[>.<+]", "var $n, $c; while +$n { print $c; }")
+}
+
+func TestAssignmentToSource(t *testing.T) {
+	expectBf(t, "[->+<]>[-<++>]", "var $a; +$a = $a;")
+}
+
+func TestScopeExitReleasesCells(t *testing.T) {
+	expectBf(t, "[>.[-]<-]>.", "var $a; while _$a { var $b; print $b; } var $c; print $c;")
+}
+
+func TestRunIfElse(t *testing.T) {
+	source := `
+		var $a;
+		var $yes = 'y';
+		var $no = 'n';
+		read $a;
+		-$a = '0';
+		if $a { print $yes; } else { print $no; }
+		if _$a { print $yes; } else { print $no; }
+		if $a { print $yes; } else { print $no; }`
+
+	expectOutput(t, "yyn", source, "1")
+	expectOutput(t, "nnn", source, "0")
+}
+
+func TestRunWhileRestoresSubject(t *testing.T) {
+	expectOutput(t, "ddddddd", `
+		var $n = 2;
+		var $c = 'd';
+		while -$n { print $c; }
+		while -$n { print $c; }
+		+$n = 1;
+		while _$n { print $c; }
+		while _$n { print $c; }`, "")
+}
+
+func TestRunMultiply(t *testing.T) {
+	expectOutput(t, "BBz!c", `
+		var $a = 11;
+		var $b = 6;
+		var $c, $d;
+		*$a = 6;
+		$c = $a * 1;
+		print $a, $c;
+		$d = $b * $b;
+		+$d = 86;
+		print $d;
+		$c = 3;
+		*$c = _$c;
+		$d = _$c * 3;
+		+$d = 6;
+		print $d;
+		$d = 3;
+		*$d = $d * 11;
+		print $d;`, "")
+}
+
+func TestRunDivMod(t *testing.T) {
+	expectOutput(t, "AABC0DE!", `
+		var $n = 100;
+		var $d = 7;
+		var $q, $r;
+		$q, $r = $n / $d;
+		+$q = 51;
+		+$r = 63;
+		print $q, $r;
+		$q = $n % 9;
+		+$q = 65;
+		print $q;
+		$q = _$n / 10;
+		+$q = 57;
+		+$n = '0';
+		print $q, $n;
+		$q = 200 / 7;
+		+$q = 40;
+		print $q;
+		$q, $r = 5 / $d;
+		+$q = 'E';
+		print $q;
+		$r = $d / $d;
+		+$r = 32;
+		print $r;`, "")
+}
+
+func TestRunComparisons(t *testing.T) {
+	source := `
+		var $a = 3;
+		var $b = 5;
+		var $out = 'a';
+		if $a < $b { print $out; }
+		if $b < $a { print $a; }
+		+$out = 1;
+		if $a != $b { print $out; }
+		+$out = 1;
+		if $a <= 3 { print $out; }
+		$out = 'E';
+		if $a == $b { print $a; } else { print $out; }
+		+$out = 1;
+		if 6 > $b { print $out; }
+		+$out = 1;
+		if _$b >= $b { print $out; }
+		$out = 'h';
+		if $b == 5 { print $a; } else { print $out; }
+		+$out = 1;
+		if $a > 2 { print $out; }`
+	expectOutput(t, "abcEFGhi", source, "")
+
+	noWrap := compiler.DefaultOptions
+	noWrap.Wrap = false
+	noWrapCfg := interp.DefaultConfig
+	noWrapCfg.Wrap = false
+	if out, bf := run(t, source, "", noWrap, noWrapCfg); out != "abcEFGhi" {
+		t.Errorf("Expected abcEFGhi without wrapping, got %q from %v", out, bf)
+	}
+}
+
+func TestRunWhileComparison(t *testing.T) {
+	expectOutput(t, "xyz", `
+		var $c;
+		read $c;
+		while $c != 'q' {
+			print $c;
+			read $c;
+		}`, "xyzq")
+}
+
+func TestRunLogical(t *testing.T) {
+	expectOutput(t, "ABCD", `
+		var $a = 3;
+		var $b;
+		var $out = 'A';
+		if $a && !$b { print $out; }
+		+$out = 1;
+		if $b || $a == 3 { print $out; }
+		if $b && $a { print $a; }
+		+$out = 1;
+		if !($a < 2 || $b) { print $out; }
+		+$out = 1;
+		if $a > 5 || $a < 1 { print $a; } else { print $out; }`, "")
+}
+
+func TestRunLogicalShortCircuits(t *testing.T) {
+	expectOutput(t, "\x03\x00", `
+		var $a = 3;
+		var $b;
+		if $a || _$a { }
+		print $a;
+		if $b && _$a { }
+		if !$b && _$a { }
+		print $a;`, "")
+}
+
+func TestRunPrintStrings(t *testing.T) {
+	expectOutput(t, "Hello, World!\n\"AB\tA\n", `
+		var $a = 'A';
+		print "Hello, World!\n";
+		print "\"", $a, 'B', 9, $a, 10;`, "")
+}
+
+func TestRunConstants(t *testing.T) {
+	noWrap := compiler.DefaultOptions
+	noWrap.Wrap = false
+	noWrapCfg := interp.DefaultConfig
+	noWrapCfg.Wrap = false
+
+	for n := 0; n < 256; n++ {
+		source := fmt.Sprintf("var $a = 7; $a = %d; print $a, %d;", n, n)
+		expected := string([]byte{byte(n), byte(n)})
+
+		if out, bf := run(t, source, "", compiler.DefaultOptions, interp.DefaultConfig); out != expected {
+			t.Errorf("Expected %q from %v, got %q from %v", expected, source, out, bf)
+		}
+		if out, bf := run(t, source, "", noWrap, noWrapCfg); out != expected {
+			t.Errorf("Expected %q from %v without wrapping, got %q from %v", expected, source, out, bf)
+		}
+	}
+}
+
+func TestConstantsAreShort(t *testing.T) {
+	if _, bf := run(t, "var $a = 200;", "", compiler.DefaultOptions, interp.DefaultConfig); len(stripComments(bf)) > 30 {
+		t.Errorf("Expected $a = 200 to wrap around, got %v", bf)
+	}
+	if _, bf := run(t, "var $a = 100;", "", compiler.DefaultOptions, interp.DefaultConfig); len(stripComments(bf)) > 30 {
+		t.Errorf("Expected $a = 100 to use a loop, got %v", bf)
+	}
+}
+
+func TestRunArrays(t *testing.T) {
+	expectOutput(t, "ABCDcA\x00", `
+		var $buf[5];
+		var $i, $x;
+		$x = 'A';
+		while $i < 5 {
+			$buf[$i] = $x;
+			+$x = 1;
+			+$i = 1;
+		}
+
+		$i = 0;
+		while $i < 4 {
+			$x = $buf[$i];
+			print $x;
+			+$i = 1;
+		}
+
+		-$i = 2;
+		+$buf[$i] = ' ';
+		$x = $buf[$i];
+		print $x;
+
+		$buf[1] = $buf[0];
+		print $buf[1];
+		$x = _$buf[$i];
+		print $buf[2];`, "")
+}
+
+func TestRunArrayScopes(t *testing.T) {
+	expectOutput(t, "aaa", `
+		def $put($arr, $i, $v) {
+			$arr[$i] = $v;
+		}
+
+		var $n = 3;
+		var $one = 1;
+		var $v = 'a';
+		while -$n {
+			var $local[2];
+			$put($local, $one, $v);
+			+$local[$one] = _$local[0];
+			$local[0] = $local[$one];
+			print $local[0];
+		}`, "")
+}
+
+func TestRunCompareElements(t *testing.T) {
+	expectOutput(t, "\x00bb\x00", `
+		var $arr[2];
+		$arr[0] = 'a';
+		$arr[1] = 'b';
+		if _$arr[0] == $arr[1] { }
+		print $arr[0], $arr[1];
+		$arr[0] = 'Y';
+		if _$arr[0] == $arr[0] { print $arr[1]; }
+		print $arr[0];`, "")
+}
+
+func TestRunDecimal(t *testing.T) {
+	expectOutput(t, "0 7 42 255 100 x 12 0 250", `
+		var $a, $b, $c;
+		print num $a;
+		print " ";
+		$a = 7;
+		print num $a;
+		print " ";
+		print num 42;
+		$a = 255;
+		print " ";
+		print num $a;
+		$a = 100;
+		print " ";
+		print num $a;
+		print " x ";
+		read num $a, $b, $c;
+		print num $a;
+		print " ";
+		print num $b;
+		print " ";
+		print num $c;`, "12 x250\n")
+}
+
+func TestRunNamedConstants(t *testing.T) {
+	expectOutput(t, "HI\nHI\nHI\n4\n", `
+		const $NEWLINE = 10;
+		const $TIMES = 3;
+		const $H, $LETTER = 72;
+		const $AREA = $TIMES * $TIMES;
+		const $HALF = $AREA / 2;
+		var $a, $arr[$TIMES];
+		$a = $LETTER;
+		+$a = 1;
+		while -$TIMES {
+			print $H, $a, $NEWLINE;
+		}
+		const $LAST = $AREA % 7;
+		$arr[$LAST] = $HALF;
+		if $arr[2] == $HALF {
+			print num $arr[2];
+			print $NEWLINE;
+		}`, "")
+}
+
+// compileErrors is the number of errors from compiling source
+func compileErrors(t *testing.T, source string) int {
+	return compileErrorsWith(t, source, compiler.DefaultOptions)
+}
+
+func compileErrorsWith(t *testing.T, source string, opts compiler.Options) int {
+	return len(compileMessages(t, source, opts))
+}
+
+// compileMessages is the message of every error from compiling source
+func compileMessages(t *testing.T, source string, opts compiler.Options) []string {
+	stmts, diags := parse.Parse(parse.Lex(source))
+	if diags.HasErrors() {
+		t.Fatalf("Unexpected parse errors:\n%v", diags)
+	}
+
+	assembler, ch := asm.New()
+	go func() {
+		compiler.Compile(assembler, stmts, opts)
+		close(ch)
+	}()
+
+	var msgs []string
+	for node := range ch {
+		if d, isErr := asm.Diagnostic(node); isErr {
+			msgs = append(msgs, d.Message)
+		}
+	}
+
+	return msgs
+}
+
+func TestConstantsCantChange(t *testing.T) {
+	for _, source := range []string{
+		"const $A = 1; $A = 2;",
+		"const $A = 1; +$A = 2;",
+		"const $A = 1; read $A;",
+		"const $A = 1; def $f($x) { } $f($A);",
+		"const $A = 1; while +$A { }",
+		"var $a; const $A = $a;",
+		"const $A = 1 / 0;",
+	} {
+		if errs := compileErrors(t, source); errs == 0 {
+			t.Errorf("Expected an error compiling %v", source)
+		}
+	}
+}
+
+func TestRepeatPicksShorter(t *testing.T) {
+	expectBf(t, "+++", "var $a; repeat 3 { +$a = 1; }")
+	expectBf(t, "..", "var $a; repeat 2 { print $a; }")
+	expectBf(t, ">>+++++[-<++++++++>]<[<.>-]", "var $a; repeat 40 { print $a; }")
+	expectBf(t, "", "var $a; repeat 0 { print $a; }")
+}
+
+func TestRepeatMoreThanACell(t *testing.T) {
+	_, bf := run(t, "var $a; repeat 100000 { print $a; }", "", compiler.DefaultOptions, interp.DefaultConfig)
+	if len(bf) > 100 {
+		t.Errorf("Expected nested loops, got %d instructions", len(bf))
+	}
+
+	// 1000 is three times round 256 with 232 left over
+	expectOutput(t, "3 232", `
+		var $a, $wraps;
+		repeat 1000 {
+			+$a = 1;
+			if $a == 0 {
+				+$wraps = 1;
+			}
+		}
+		print num $wraps;
+		print ' ';
+		print num $a;`, "")
+}
+
+func TestRepeatNestedCompilesBodyOnce(t *testing.T) {
+	// Compiling each body twice would take twice as long for every level
+	source := "var $a;" + strings.Repeat("repeat 2 {", 40) + "+$a = 1;" + strings.Repeat("}", 40)
+	if errs := compileErrors(t, source); errs != 0 {
+		t.Errorf("Unexpected errors compiling nested repeats")
+	}
+}
+
+func TestRunRepeat(t *testing.T) {
+	expectOutput(t, "ab.ab.ab.ab.ab.ab.ab.ab.ab.ab.\n40", `
+		const $TIMES = 10;
+		var $a;
+		repeat $TIMES {
+			print "ab.";
+			repeat 4 {
+				+$a = 1;
+			}
+		}
+		print 10;
+		print num $a;`, "")
+}
+
+func TestRunBreakAndContinue(t *testing.T) {
+	expectOutput(t, "0123|1357|9 8 7 |ababa5|ccccc", `
+		var $i, $n;
+		while $i < 10 {
+			if $i == 4 {
+				break;
+			}
+			print num $i;
+			+$i = 1;
+		}
+		print '|';
+
+		var $odd;
+		$i = 0;
+		while $i < 8 {
+			+$i = 1;
+			$odd = $i % 2;
+			if !_$odd {
+				continue;
+			}
+			print num $i;
+		}
+		print '|';
+
+		$n = 9;
+		while _$n {
+			print num $n;
+			print ' ';
+			if $n == 7 {
+				break;
+			}
+		}
+		print '|';
+
+		$n = 5;
+		while -$n {
+			print 'a';
+			if $n == 3 {
+				break;
+			}
+			print 'b';
+		}
+		print num $n;
+		print '|';
+
+		while -$n {
+			repeat 3 {
+				print 'c';
+				continue;
+			}
+			print 'd';
+		}`, "")
+}
+
+func TestJumpOutsideLoop(t *testing.T) {
+	for _, source := range []string{
+		"break;",
+		"repeat 2 { continue; }",
+		"def $f() { break; } var $a = 1; while _$a { $f(); }",
+	} {
+		if errs := compileErrors(t, source); errs == 0 {
+			t.Errorf("Expected an error compiling %v", source)
+		}
+	}
+}
+
+func TestLiteralParams(t *testing.T) {
+	expectBf(t, "+++>++++", `
+		var $a, $b;
+		def $addN($v, #n) { +$v = #n; }
+		def $addTwice($v, #n) { $addN($v, #n); $addN($v, #n); }
+		$addN($a, 3);
+		$addTwice($b, 2);`)
+}
+
+func TestRunLiteralParams(t *testing.T) {
+	expectOutput(t, "*****\n---\nzz", `
+		const $DASH = '-';
+		def $printN(#c, #n) {
+			repeat #n {
+				print #c;
+			}
+			print 10;
+		}
+		$printN('*', 5);
+		$printN($DASH, 3);
+
+		var $arr[3];
+		def $set($a, #i, #v) { $a[#i] = #v; }
+		$set($arr, 2, 'z');
+		print $arr[2];
+		$set($arr, 0, 'z');
+		print $arr[0];`, "")
+}
+
+func TestLiteralParamsRejectVariables(t *testing.T) {
+	for _, source := range []string{
+		"var $a, $b; def $addN($v, #n) { +$v = #n; } $addN($a, $b);",
+		"var $a; def $addN($v, #n) { +$v = #n; } $addN(1, 1);",
+		"var $a; def $f($v, #n) { +$v = #m; } $f($a, 1);",
+		"var $a = #n;",
+	} {
+		if errs := compileErrors(t, source); errs == 0 {
+			t.Errorf("Expected an error compiling %v", source)
+		}
+	}
+}
+
+func TestRunRicherLiterals(t *testing.T) {
+	expectOutput(t, "A\tB\n'\\", `
+		var $a = 0x42;
+		+$a = -1;
+		print $a, '\t', 0b1000010, '\n', '\'', '\\';`, "")
+}
+
+func TestLiteralsFitCells(t *testing.T) {
+	for _, source := range []string{
+		"var $a = 256;",
+		"var $a; +$a = -256;",
+		"var $a; print 300;",
+		"var $a; if $a == 0x100 { }",
+		"const $BIG = 1000; var $a = $BIG;",
+		"var $q = 30 / -2;",
+		"var $a, $q; $a = 30; $q = $a / -2;",
+		"var $a, $q; $a = 30; $q = $a % -7;",
+		"var $a, $q; $a = 30; $q = $a * -2;",
+		"var $a; *$a = -2;",
+		"var $a = 3; if $a > -1 { }",
+		"if 3 > -1 { }",
+		"var $a; while $a != -1 { }",
+		"print -5;",
+		"print num -5;",
+		"const $MINUS = -5; print $MINUS;",
+		"var $a; def $addN($v, #n) { +$v = #n; } $addN($a, -3);",
+		"var $a; $a = 3 * 100;",
+		"const $B = 20 * 20; var $a = $B;",
+	} {
+		if errs := compileErrors(t, source); errs == 0 {
+			t.Errorf("Expected an error compiling %v", source)
+		}
+	}
+
+	for _, source := range []string{
+		"var $a = -1;",
+		"var $a, $b; $a, +$b = -1;",
+		"var $a; -$a = -255;",
+		"var $a; $a = 3 * 85;",
+		"var $a; $a = 255 / 3;",
+	} {
+		if errs := compileErrors(t, source); errs != 0 {
+			t.Errorf("Unexpected errors compiling %v", source)
+		}
+	}
+
+	// Sizes and counts aren't kept in cells
+	expectOutput(t, "1 44", `
+		const $BIG = 300;
+		var $arr[$BIG], $n, $wraps;
+		repeat $BIG {
+			+$arr[299] = 1;
+			if $arr[299] == 0 {
+				+$wraps = 1;
+			}
+		}
+		$n = $arr[299];
+		print num $wraps;
+		print ' ';
+		print num $n;`, "")
+}
+
+func TestOutOfMemoryOncePerStatement(t *testing.T) {
+	opts := compiler.DefaultOptions
+	opts.Cells = 3
+
+	source := "var $a, $b, $c; $a = $b * $c; print num $a;"
+	if errs := compileErrorsWith(t, source, opts); errs != 2 {
+		t.Errorf("Expected an error for each of the two statements, got %d", errs)
+	}
+}
+
+func TestOutOfMemoryReportsLimit(t *testing.T) {
+	msgs := compileMessages(t, "var $a[99999999999999];", compiler.DefaultOptions)
+	expected := fmt.Sprintf("Ran out of memory, the tape is limited to %d cells", compiler.DefaultOptions.Cells)
+	if len(msgs) != 1 || msgs[0] != expected {
+		t.Errorf("Expected %q, got %q", expected, msgs)
+	}
+}
@@ -0,0 +1,258 @@
+package compiler
+
+import (
+	"asm"
+	"parse"
+)
+
+// compileCondition adds one onto flag, which must be zero, when the condition
+// holds. Floored operands are left at zero, others are left as they were.
+func compileCondition(p *program, cond parse.Expr, flag asm.Pointer) {
+	switch cond := cond.(type) {
+	case parse.Lit:
+		if cond.Val != 0 {
+			p.asm.Add(flag, 1)
+		}
+	case parse.Ident:
+		temp := p.Temp(flag)
+		loadOperand(p, cond, temp, 1)
+		setIfNonZero(p, temp, flag)
+		p.Free(temp)
+	case parse.Comparison:
+		compileComparison(p, cond, flag)
+	case parse.Not:
+		negate(p, flag, func(f asm.Pointer) { compileCondition(p, cond.Expr, f) })
+	case parse.Logical:
+		compileLogical(p, cond, flag)
+	default:
+		p.asm.Err(p.stmt, "Unexpected condition %v", cond)
+	}
+}
+
+func compileComparison(p *program, cond parse.Comparison, flag asm.Pointer) {
+	lhs, rhs := cond.Lhs, cond.Rhs
+
+	switch cond.Op {
+	case parse.Equal, parse.NotEqual:
+		lhs, rhs := unalias(p, lhs, rhs)
+		diff := p.Temp(flag)
+		if p.opts.Wrap {
+			loadOperand(p, lhs, diff, 1)
+			loadOperand(p, rhs, diff, -1)
+		} else {
+			distance(p, lhs, rhs, diff)
+		}
+
+		if cond.Op == parse.Equal {
+			p.asm.Add(flag, 1)
+			p.asm.OpenLoop(diff)
+			p.asm.Add(flag, -1)
+			p.Clear(diff)
+			p.asm.CloseLoop()
+		} else {
+			setIfNonZero(p, diff, flag)
+		}
+		p.Free(diff)
+	case parse.Less:
+		lessThan(p, lhs, rhs, flag)
+	case parse.Greater:
+		lessThan(p, rhs, lhs, flag)
+	case parse.LessEqual:
+		negate(p, flag, func(lt asm.Pointer) { lessThan(p, rhs, lhs, lt) })
+	case parse.GreaterEqual:
+		negate(p, flag, func(lt asm.Pointer) { lessThan(p, lhs, rhs, lt) })
+	default:
+		p.asm.Err(p.stmt, "Unknown comparison %v", cond.Op)
+	}
+}
+
+// compileLogical only evaluates the right hand side when the left hand side
+// didn't already decide the result
+func compileLogical(p *program, cond parse.Logical, flag asm.Pointer) {
+	lhs := p.Temp(flag)
+	compileCondition(p, cond.Lhs, lhs)
+
+	switch cond.Op {
+	case parse.And:
+		p.asm.OpenLoop(lhs)
+		p.asm.Add(lhs, -1)
+		compileCondition(p, cond.Rhs, flag)
+		p.asm.CloseLoop()
+	case parse.Or:
+		otherwise := p.Temp(flag)
+		p.asm.Add(otherwise, 1)
+		p.asm.OpenLoop(lhs)
+		p.asm.Add(lhs, -1)
+		p.asm.Add(otherwise, -1)
+		p.asm.Add(flag, 1)
+		p.asm.CloseLoop()
+
+		p.asm.OpenLoop(otherwise)
+		p.asm.Add(otherwise, -1)
+		compileCondition(p, cond.Rhs, flag)
+		p.asm.CloseLoop()
+		p.Free(otherwise)
+	default:
+		p.asm.Err(p.stmt, "Unknown operator %v", cond.Op)
+		p.Clear(lhs)
+	}
+
+	p.Free(lhs)
+}
+
+// lessThan counts down a copy of x, taking one off a copy of y each time
+// unless it has already reached zero. Whatever is left of y is how much
+// bigger it was than x.
+func lessThan(p *program, x, y parse.Expr, flag asm.Pointer) {
+	xLit, xIsLit := x.(parse.Lit)
+	yLit, yIsLit := y.(parse.Lit)
+	if xIsLit && yIsLit {
+		if xLit.Val < yLit.Val {
+			p.asm.Add(flag, 1)
+		}
+		return
+	}
+
+	x, y = unalias(p, x, y)
+	counter, left := p.Temp(flag), p.Temp(flag)
+	loadOperand(p, x, counter, 1)
+	loadOperand(p, y, left, 1)
+	countDown(p, counter, left, asm.NullPointer)
+	p.Free(counter)
+
+	setIfNonZero(p, left, flag)
+	p.Free(left)
+}
+
+// distance adds how far apart x and y are onto to, which must be zero,
+// without letting any cell go below zero on the way
+func distance(p *program, x, y parse.Expr, to asm.Pointer) {
+	counter, short := p.Temp(to), p.Temp(to)
+	loadOperand(p, x, counter, 1)
+	loadOperand(p, y, to, 1)
+	countDown(p, counter, to, short)
+	p.Free(counter)
+
+	p.Move(short, to)
+	p.Free(short)
+}
+
+// countDown empties counter, taking one off left each time until left reaches
+// zero. After that each time adds one onto short instead, unless it is null.
+func countDown(p *program, counter, left, short asm.Pointer) {
+	nonZero, test := p.Temp(left), p.Temp(left)
+	p.asm.OpenLoop(counter)
+	p.asm.Add(counter, -1)
+	if short != asm.NullPointer {
+		p.asm.Add(short, 1)
+	}
+
+	p.Move(left, test)
+	p.asm.OpenLoop(test)
+	p.Move(test, left)
+	p.asm.Add(nonZero, 1)
+	p.asm.CloseLoop()
+
+	p.asm.OpenLoop(nonZero)
+	p.asm.Add(nonZero, -1)
+	p.asm.Add(left, -1)
+	if short != asm.NullPointer {
+		p.asm.Add(short, -1)
+	}
+	p.asm.CloseLoop()
+	p.asm.CloseLoop()
+	p.Free(nonZero)
+	p.Free(test)
+}
+
+// negate sets flag to the opposite of the condition compiled by cond
+func negate(p *program, flag asm.Pointer, cond func(asm.Pointer)) {
+	inverse := p.Temp(flag)
+	cond(inverse)
+
+	p.asm.Add(flag, 1)
+	p.asm.OpenLoop(inverse)
+	p.asm.Add(inverse, -1)
+	p.asm.Add(flag, -1)
+	p.asm.CloseLoop()
+	p.Free(inverse)
+}
+
+// setIfNonZero adds one onto flag if pt is non-zero, leaving pt at zero
+func setIfNonZero(p *program, pt, flag asm.Pointer) {
+	p.asm.OpenLoop(pt)
+	p.asm.Add(flag, 1)
+	p.Clear(pt)
+	p.asm.CloseLoop()
+}
+
+// loadOperand adds n times the value of the operand onto to
+func loadOperand(p *program, operand parse.Expr, to asm.Pointer, n int) {
+	switch operand := operand.(type) {
+	case parse.Lit:
+		p.AddConst(to, operand.Val*n)
+	case parse.Ident:
+		if operand.Op != parse.None && operand.Op != parse.Floor {
+			p.asm.Err(operand, "Unexpected operator in condition")
+			return
+		}
+		addScaled(p, operand, to, n)
+	}
+}
+
+// unalias moves the floor from x onto y when they are the same cell, as
+// loading x first would zero y before it was read
+func unalias(p *program, x, y parse.Expr) (parse.Expr, parse.Expr) {
+	xIdent, xIsIdent := x.(parse.Ident)
+	yIdent, yIsIdent := y.(parse.Ident)
+	if !xIsIdent || !yIsIdent || xIdent.Op != parse.Floor || !sameCell(p, xIdent, yIdent) {
+		return x, y
+	}
+
+	xIdent.Op, yIdent.Op = parse.None, parse.Floor
+	return xIdent, yIdent
+}
+
+// sameCell is whether x and y are the same variable, or the same element of
+// an array. It reports nothing, leaving that to whatever loads them.
+func sameCell(p *program, x, y parse.Ident) bool {
+	xVar, xOk := p.sc.Get(x.Id)
+	yVar, yOk := p.sc.Get(y.Id)
+	if !xOk || !yOk || xVar.Value != yVar.Value {
+		return false
+	}
+
+	if _, isArray := xVar.Value.(*array); !isArray {
+		return true
+	}
+
+	xIndex, xIsLit := p.substitute(x.Index).(parse.Lit)
+	yIndex, yIsLit := p.substitute(y.Index).(parse.Lit)
+	return xIsLit && yIsLit && xIndex.Val == yIndex.Val
+}
+
+// conditionNear is a cell used in the condition, for keeping the flag close by
+func conditionNear(p *program, cond parse.Expr) asm.Pointer {
+	switch cond := cond.(type) {
+	case parse.Ident:
+		if variable, ok := p.sc.Get(cond.Id); ok {
+			if pt, isPt := variable.Value.(int); isPt {
+				return asm.Pointer(pt)
+			}
+		}
+	case parse.Comparison:
+		if near := conditionNear(p, cond.Lhs); near != asm.NullPointer {
+			return near
+		}
+		return conditionNear(p, cond.Rhs)
+	case parse.Logical:
+		if near := conditionNear(p, cond.Lhs); near != asm.NullPointer {
+			return near
+		}
+		return conditionNear(p, cond.Rhs)
+	case parse.Not:
+		return conditionNear(p, cond.Expr)
+	}
+
+	return asm.NullPointer
+}
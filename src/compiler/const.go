@@ -0,0 +1,121 @@
+package compiler
+
+import (
+	"asm"
+)
+
+// maxConstCounter bounds the loop counters tried when planning a constant
+const maxConstCounter = 256
+
+// constPlan is a way of adding a constant onto a cell. A non-zero counter is
+// put in a scratch cell and counted down, adding factor each time around, and
+// then rest is added directly.
+type constPlan struct {
+	counter int
+	factor  int
+	rest    int
+}
+
+// cost is the number of instructions in the plan, with the scratch cell dist
+// cells away from the target
+func (c constPlan) cost(dist int) int {
+	if c.counter == 0 {
+		return abs(c.rest)
+	}
+
+	// The counter, the factor and the rest, then [-] and getting to and from
+	// the scratch cell twice
+	return c.counter + abs(c.factor) + abs(c.rest) + 3 + 4*dist
+}
+
+// planConst finds the shortest plan for adding n
+func (p *program) planConst(n int, dist int) constPlan {
+	best := constPlan{rest: p.direct(n)}
+	for _, v := range p.equivalents(n) {
+		for counter := 2; counter <= abs(v)/2 && counter <= maxConstCounter; counter++ {
+			for factor := v/counter - 1; factor <= v/counter+1; factor++ {
+				plan := constPlan{counter: counter, factor: factor, rest: v - counter*factor}
+				if !p.opts.Wrap && plan.rest*plan.factor < 0 {
+					continue // Overshooting would overflow or underflow
+				}
+
+				if plan.cost(dist) < best.cost(dist) {
+					best = plan
+				}
+			}
+		}
+	}
+
+	return best
+}
+
+// maxValue is the biggest value a cell can hold
+func (p *program) maxValue() int {
+	return 1<<uint(p.opts.CellBits) - 1
+}
+
+// equivalents are the values that add the same as n onto a cell. Without
+// wrapping that's only n itself, with it n can go either way round.
+func (p *program) equivalents(n int) []int {
+	if !p.opts.Wrap {
+		return []int{n}
+	}
+
+	modulus := 1 << uint(p.opts.CellBits)
+	up := ((n % modulus) + modulus) % modulus
+	if up == 0 {
+		return []int{0}
+	}
+
+	return []int{up, up - modulus}
+}
+
+// direct is the shortest equivalent of n
+func (p *program) direct(n int) int {
+	best := n
+	for _, v := range p.equivalents(n) {
+		if abs(v) < abs(best) {
+			best = v
+		}
+	}
+
+	return best
+}
+
+// constCost is roughly how many instructions AddConst would use for n
+func (p *program) constCost(n int) int {
+	return p.planConst(n, 1).cost(1)
+}
+
+// AddConst adds n onto the cell in as few instructions as it can, using a
+// multiplication loop on a scratch cell when that is shorter
+func (p *program) AddConst(pt asm.Pointer, n int) {
+	plan := p.planConst(n, 1)
+	if plan.counter == 0 {
+		p.asm.Add(pt, plan.rest)
+		return
+	}
+
+	// Not being able to get a scratch cell isn't an error, it's just longer
+	tempPt, err := p.mem.Malloc(int(pt))
+	if err != nil {
+		p.asm.Add(pt, p.direct(n))
+		return
+	}
+	temp := asm.Pointer(tempPt)
+
+	if dist := abs(int(temp - pt)); dist > 1 {
+		plan = p.planConst(n, dist)
+	}
+
+	if plan.counter != 0 {
+		p.asm.Add(temp, plan.counter)
+		p.asm.OpenLoop(temp)
+		p.asm.Add(temp, -1)
+		p.asm.Add(pt, plan.factor)
+		p.asm.CloseLoop()
+	}
+	p.asm.Add(pt, plan.rest)
+
+	p.Free(temp)
+}
@@ -0,0 +1,132 @@
+package compiler
+
+import (
+	"asm"
+	"parse"
+	"strconv"
+)
+
+// maxDigits is how many decimal digits the biggest value of a cell has
+func (p *program) maxDigits() int {
+	return len(strconv.Itoa(p.maxValue()))
+}
+
+// compileDecimalPrint prints every argument as a decimal number
+func compileDecimalPrint(p *program, expr parse.PrintStmt) {
+	for _, arg := range expr.Args {
+		switch arg := p.resolve(arg).(type) {
+		case parse.Lit:
+			printConstant(p, []byte(strconv.Itoa(arg.Val)))
+		case parse.Ident:
+			if arg.Op != parse.None {
+				p.asm.Err(arg, "Unexpected operator in print statement")
+			}
+
+			if pt, ok := p.GetPt(arg); ok {
+				printDecimal(p, pt)
+			}
+		default:
+			p.asm.Err(p.stmt, "Only numbers can be printed with print num")
+		}
+	}
+}
+
+// printDecimal splits a copy of the cell into its digits by dividing by ten
+// over and over. The digits are then printed from the top, skipping any
+// leading zeros, which are spotted by keeping a running total of the digits.
+func printDecimal(p *program, pt asm.Pointer) {
+	ten := func(pt asm.Pointer) { p.AddConst(pt, 10) }
+
+	digits := make([]asm.Pointer, p.maxDigits())
+	counter := p.Temp(pt)
+	p.Copy(pt, counter)
+	for i := range digits {
+		digits[i] = p.Temp(counter)
+		if i == len(digits)-1 {
+			p.Move(counter, digits[i])
+			break
+		}
+
+		quotient := p.Temp(counter)
+		divMod(p, counter, quotient, digits[i], ten)
+		p.Free(counter)
+		counter = quotient
+	}
+	p.Free(counter)
+
+	total, test, show := p.Temp(pt), p.Temp(pt), p.Temp(pt)
+	for i := len(digits) - 1; i > 0; i-- {
+		digit := digits[i]
+		p.Copy(digit, total)
+
+		p.Move(total, test)
+		p.asm.OpenLoop(test)
+		p.Move(test, total)
+		p.asm.Add(show, 1)
+		p.asm.CloseLoop()
+
+		p.asm.OpenLoop(show)
+		p.asm.Add(show, -1)
+		p.AddConst(digit, '0')
+		p.asm.Print(digit)
+		p.AddConst(digit, -'0')
+		p.asm.CloseLoop()
+
+		p.Clear(digit)
+		p.Free(digit)
+	}
+	p.Clear(total)
+	p.Free(total)
+	p.Free(test)
+	p.Free(show)
+
+	p.AddConst(digits[0], '0')
+	p.asm.Print(digits[0])
+	p.Clear(digits[0])
+	p.Free(digits[0])
+}
+
+// readDecimal reads digits into the cell until something else is read, which
+// is thrown away. Each digit is added on after multiplying by ten.
+func readDecimal(p *program, id parse.Ident) {
+	pt, ok := p.GetPt(id)
+	if !ok {
+		return
+	}
+
+	c, more, isDigit := p.Temp(pt), p.Temp(pt), p.Temp(pt)
+
+	// The check is an ordinary condition on a name no program can use
+	p.EnterScope()
+	name := "read num"
+	p.sc.Define(&name, int(c))
+	char := parse.Ident{Id: name}
+	digitCheck := parse.Logical{
+		Op:  parse.And,
+		Lhs: parse.Comparison{Op: parse.GreaterEqual, Lhs: char, Rhs: parse.Lit{Val: '0'}},
+		Rhs: parse.Comparison{Op: parse.LessEqual, Lhs: char, Rhs: parse.Lit{Val: '9'}},
+	}
+
+	p.Clear(pt)
+	p.asm.Add(more, 1)
+	p.asm.OpenLoop(more)
+	p.asm.Add(more, -1)
+	p.asm.Read(c)
+
+	compileCondition(p, digitCheck, isDigit)
+	p.asm.OpenLoop(isDigit)
+	p.asm.Add(isDigit, -1)
+	p.asm.Add(more, 1)
+	p.AddConst(c, -'0')
+	p.Scale(pt, 10)
+	p.Move(c, pt)
+	p.asm.CloseLoop()
+
+	p.Clear(c)
+	p.asm.CloseLoop()
+
+	p.ExitScope()
+	p.Free(c)
+	p.Free(more)
+	p.Free(isDigit)
+}
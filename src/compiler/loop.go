@@ -0,0 +1,157 @@
+package compiler
+
+import (
+	"asm"
+	"parse"
+)
+
+// loop is a while loop whose body can break or continue. Live is one while
+// the rest of the body should run, and broken is set once it has broken out.
+type loop struct {
+	live   asm.Pointer
+	broken asm.Pointer
+}
+
+// jumps is whether expr can break or continue the innermost loop around it
+func jumps(expr parse.Expr) bool {
+	switch expr := expr.(type) {
+	case parse.BreakStmt, parse.ContinueStmt:
+		return true
+	case parse.Stmt:
+		return jumps(expr.Expr)
+	case parse.StmtCollection:
+		for _, stmt := range expr {
+			if jumps(stmt) {
+				return true
+			}
+		}
+	case parse.IfStmt:
+		return jumps(expr.Body) || jumps(expr.Else)
+	case parse.RepeatStmt:
+		return jumps(expr.Body)
+	}
+
+	return false
+}
+
+func compileJump(p *program, jump parse.Expr) {
+	if len(p.loops) == 0 {
+		p.asm.Err(p.stmt, "%v can only be used inside a while loop", jump)
+		return
+	}
+
+	l := p.loops[len(p.loops)-1]
+	p.asm.Add(l.live, -1)
+	if _, isBreak := jump.(parse.BreakStmt); isBreak {
+		p.asm.Add(l.broken, 1)
+	}
+}
+
+// guard only runs the code from compile while the innermost loop hasn't been
+// broken or continued
+func guard(p *program, compile func()) {
+	l := p.loops[len(p.loops)-1]
+	flag := p.Temp(l.live)
+	p.Move(l.live, flag)
+	p.asm.OpenLoop(flag)
+	p.Move(flag, l.live)
+	compile()
+	p.asm.CloseLoop()
+	p.Free(flag)
+}
+
+// compileBreakableWhile is compileWhileStmt for a body that breaks or
+// continues. The subject is stepped as usual after every time around that
+// didn't break, a floored subject is cleared when it does.
+func compileBreakableWhile(p *program, expr parse.WhileStmt) {
+	subject, isIdent := expr.Subject.(parse.Ident)
+	_, isConst := p.constant(subject)
+	if !isIdent || (isConst && subject.Op == parse.None) {
+		cond := p.resolve(expr.Subject)
+		breakableLoop(p, expr.Body, conditionNear(p, cond),
+			func(flag asm.Pointer) { compileCondition(p, cond, flag) }, func() {}, func() {})
+		return
+	}
+
+	var pt asm.Pointer
+	if n, isConst := p.constant(subject); isConst {
+		if subject.Op != parse.Sub && subject.Op != parse.Floor {
+			p.asm.Err(subject, "%v is a constant, it can't be changed", subject.Id)
+			return
+		}
+
+		// Counting down a copy of a constant is the same as flooring it
+		pt = p.Temp(asm.NullPointer)
+		p.AddConst(pt, n)
+		defer p.Free(pt)
+		subject.Op = parse.Floor
+	} else if pt, isIdent = p.GetPt(subject); !isIdent {
+		return
+	}
+
+	step, onBreak := func() {}, func() {}
+	counter := asm.NullPointer
+	switch subject.Op {
+	case parse.Add:
+		step = func() { p.asm.Add(pt, 1) }
+	case parse.Sub:
+		counter = p.Temp(pt)
+		step = func() {
+			p.asm.Add(pt, -1)
+			p.asm.Add(counter, 1)
+		}
+	case parse.Floor:
+		step = func() { p.asm.Add(pt, -1) }
+		onBreak = func() { p.Clear(pt) }
+	}
+
+	nonZero := func(flag asm.Pointer) {
+		temp := p.Temp(flag)
+		p.Copy(pt, temp)
+		setIfNonZero(p, temp, flag)
+		p.Free(temp)
+	}
+	breakableLoop(p, expr.Body, pt, nonZero, step, onBreak)
+
+	if counter != asm.NullPointer {
+		p.Move(counter, pt)
+		p.Free(counter)
+	}
+}
+
+// breakableLoop goes around while cond adds one onto its flag, stepping
+// after every time around that didn't break
+func breakableLoop(p *program, body parse.StmtCollection, near asm.Pointer, cond func(asm.Pointer), step, onBreak func()) {
+	l := &loop{live: p.Temp(near), broken: p.Temp(near)}
+	run := p.Temp(near)
+
+	cond(run)
+	p.asm.OpenLoop(run)
+	p.asm.Add(run, -1)
+	p.asm.Add(l.live, 1)
+
+	p.loops = append(p.loops, l)
+	compileScopedBody(p, body)
+	p.loops = p.loops[:len(p.loops)-1]
+	p.Clear(l.live)
+
+	carryOn := p.Temp(near)
+	p.asm.Add(carryOn, 1)
+	p.asm.OpenLoop(l.broken)
+	p.asm.Add(l.broken, -1)
+	p.asm.Add(carryOn, -1)
+	onBreak()
+	p.asm.CloseLoop()
+
+	p.asm.OpenLoop(carryOn)
+	p.asm.Add(carryOn, -1)
+	step()
+	cond(run)
+	p.asm.CloseLoop()
+	p.Free(carryOn)
+
+	p.asm.CloseLoop()
+	p.Free(run)
+	p.Free(l.live)
+	p.Free(l.broken)
+}
@@ -0,0 +1,89 @@
+package diag
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Warning:
+		return "warning"
+	default:
+		return "error"
+	}
+}
+
+// Pos is a position in a source file. Lines and columns count from one, a zero
+// line means the position isn't known.
+type Pos struct {
+	File string
+	Line int
+	Col  int
+}
+
+func (p Pos) String() string {
+	switch {
+	case p.Line == 0:
+		return p.File
+	case p.File == "":
+		return fmt.Sprintf("%d:%d", p.Line, p.Col)
+	default:
+		return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Col)
+	}
+}
+
+type Diagnostic struct {
+	Pos
+	Severity Severity
+	Message  string
+}
+
+func (d Diagnostic) String() string {
+	if pos := d.Pos.String(); pos != "" {
+		return fmt.Sprintf("%s: %v: %s", pos, d.Severity, d.Message)
+	}
+
+	return fmt.Sprintf("%v: %s", d.Severity, d.Message)
+}
+
+func (d Diagnostic) Error() string {
+	return d.String()
+}
+
+// List collects the diagnostics of a run in the order they were found
+type List []Diagnostic
+
+func (l *List) Add(pos Pos, severity Severity, message string, args ...interface{}) {
+	*l = append(*l, Diagnostic{pos, severity, fmt.Sprintf(message, args...)})
+}
+
+func (l *List) Errorf(pos Pos, message string, args ...interface{}) {
+	l.Add(pos, Error, message, args...)
+}
+
+func (l List) HasErrors() bool {
+	for _, d := range l {
+		if d.Severity == Error {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (l List) String() string {
+	lines := make([]string, len(l))
+	for i, d := range l {
+		lines[i] = d.String()
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,185 @@
+package interp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// EOFMode is what , leaves in the cell once the input is exhausted
+type EOFMode int
+
+const (
+	EOFZero EOFMode = iota
+	EOFMinusOne
+	EOFUnchanged
+)
+
+type Config struct {
+	TapeLen  int
+	CellBits int     // 8, 16 or 32
+	Wrap     bool    // Wrap around on overflow and underflow instead of failing
+	EOF      EOFMode // What reading past the end of the input does
+}
+
+var DefaultConfig = Config{
+	TapeLen:  30000,
+	CellBits: 8,
+	Wrap:     true,
+	EOF:      EOFZero,
+}
+
+var (
+	ErrCellBits   error = errors.New("Cell width must be 8, 16 or 32 bits")
+	ErrUnbalanced error = errors.New("Unbalanced brackets")
+)
+
+// RuntimeError is a failure while the program was running, pos is the index
+// of the offending command in the program.
+type RuntimeError struct {
+	Pos     int
+	Message string
+}
+
+func (e RuntimeError) Error() string {
+	return fmt.Sprintf("Runtime Error at %d. %s", e.Pos, e.Message)
+}
+
+type machine struct {
+	cfg   Config
+	max   uint64
+	tape  []uint64
+	ptr   int
+	in    *bufio.Reader
+	out   *bufio.Writer
+	jumps map[int]int
+}
+
+// Run executes a brainfuck program, anything that isn't a command is ignored
+func Run(program string, in io.Reader, out io.Writer, cfg Config) error {
+	if cfg.CellBits != 8 && cfg.CellBits != 16 && cfg.CellBits != 32 {
+		return ErrCellBits
+	}
+
+	jumps, err := matchBrackets(program)
+	if err != nil {
+		return err
+	}
+
+	m := &machine{
+		cfg:   cfg,
+		max:   1<<uint(cfg.CellBits) - 1,
+		tape:  make([]uint64, cfg.TapeLen),
+		in:    bufio.NewReader(in),
+		out:   bufio.NewWriter(out),
+		jumps: jumps,
+	}
+
+	err = m.run(program)
+	if flushErr := m.out.Flush(); err == nil {
+		err = flushErr
+	}
+
+	return err
+}
+
+func matchBrackets(program string) (map[int]int, error) {
+	jumps := make(map[int]int)
+	open := make([]int, 0, 10)
+
+	for i := 0; i < len(program); i++ {
+		switch program[i] {
+		case '[':
+			open = append(open, i)
+		case ']':
+			if len(open) == 0 {
+				return nil, ErrUnbalanced
+			}
+			start := open[len(open)-1]
+			open = open[:len(open)-1]
+
+			jumps[start] = i
+			jumps[i] = start
+		}
+	}
+
+	if len(open) > 0 {
+		return nil, ErrUnbalanced
+	}
+
+	return jumps, nil
+}
+
+func (m *machine) run(program string) error {
+	for pc := 0; pc < len(program); pc++ {
+		switch program[pc] {
+		case '>':
+			if m.ptr++; m.ptr >= len(m.tape) {
+				return RuntimeError{pc, "Moved past the end of the tape"}
+			}
+		case '<':
+			if m.ptr--; m.ptr < 0 {
+				return RuntimeError{pc, "Moved past the start of the tape"}
+			}
+		case '+':
+			if m.tape[m.ptr] < m.max {
+				m.tape[m.ptr]++
+			} else if m.cfg.Wrap {
+				m.tape[m.ptr] = 0
+			} else {
+				return RuntimeError{pc, "Cell overflow"}
+			}
+		case '-':
+			if m.tape[m.ptr] > 0 {
+				m.tape[m.ptr]--
+			} else if m.cfg.Wrap {
+				m.tape[m.ptr] = m.max
+			} else {
+				return RuntimeError{pc, "Cell underflow"}
+			}
+		case '.':
+			if err := m.out.WriteByte(byte(m.tape[m.ptr])); err != nil {
+				return err
+			}
+		case ',':
+			if err := m.read(); err != nil {
+				return err
+			}
+		case '[':
+			if m.tape[m.ptr] == 0 {
+				pc = m.jumps[pc]
+			}
+		case ']':
+			if m.tape[m.ptr] != 0 {
+				pc = m.jumps[pc]
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m *machine) read() error {
+	// Anything written so far should be seen before we block on input
+	if err := m.out.Flush(); err != nil {
+		return err
+	}
+
+	b, err := m.in.ReadByte()
+	switch {
+	case err == io.EOF:
+		switch m.cfg.EOF {
+		case EOFZero:
+			m.tape[m.ptr] = 0
+		case EOFMinusOne:
+			m.tape[m.ptr] = m.max
+		}
+		return nil
+	case err != nil:
+		return err
+	}
+
+	m.tape[m.ptr] = uint64(b)
+	return nil
+}
@@ -0,0 +1,80 @@
+package interp_test
+
+import (
+	"bytes"
+	"interp"
+	"strings"
+	"testing"
+)
+
+func expectOutput(t *testing.T, expected string, program string, input string, cfg interp.Config) {
+	out := &bytes.Buffer{}
+	if err := interp.Run(program, strings.NewReader(input), out, cfg); err != nil {
+		t.Fatalf("Unexpected error running %v: %v", program, err)
+	}
+
+	if out.String() != expected {
+		t.Errorf("\nProgram:\t%v\nExpect:\t%q\nActual:\t%q", program, expected, out.String())
+	}
+}
+
+func TestHello(t *testing.T) {
+	program := "++++++++[>+++++++++<-]>.<+++[>++++++++++<-]>-.+++++++..+++."
+	expectOutput(t, "Hello", program, "", interp.DefaultConfig)
+}
+
+func TestEcho(t *testing.T) {
+	expectOutput(t, "cba", ",>,>,.<.<.", "abc", interp.DefaultConfig)
+}
+
+func TestWrap(t *testing.T) {
+	cfg := interp.DefaultConfig
+	expectOutput(t, "\xff", "-.", "", cfg)
+
+	// 256 is only non-zero when the cells are wider than a byte
+	program := strings.Repeat("+", 256) + "[.[-]]"
+	expectOutput(t, "", program, "", cfg)
+
+	cfg.CellBits = 16
+	expectOutput(t, "\x00", program, "", cfg)
+}
+
+func TestNoWrap(t *testing.T) {
+	cfg := interp.DefaultConfig
+	cfg.Wrap = false
+
+	err := interp.Run("+-.-", strings.NewReader(""), &bytes.Buffer{}, cfg)
+	if rtErr, ok := err.(interp.RuntimeError); !ok || rtErr.Pos != 3 {
+		t.Errorf("Expected an underflow at 3, got %v", err)
+	}
+}
+
+func TestEOF(t *testing.T) {
+	cfg := interp.DefaultConfig
+	expectOutput(t, "\x00", "+,.", "", cfg)
+
+	cfg.EOF = interp.EOFMinusOne
+	expectOutput(t, "\xff", "+,.", "", cfg)
+
+	cfg.EOF = interp.EOFUnchanged
+	expectOutput(t, "\x01", "+,.", "", cfg)
+}
+
+func TestTapeBounds(t *testing.T) {
+	cfg := interp.DefaultConfig
+	cfg.TapeLen = 2
+
+	if err := interp.Run(">>", strings.NewReader(""), &bytes.Buffer{}, cfg); err == nil {
+		t.Error("Expected an error moving past the end of the tape")
+	}
+
+	if err := interp.Run("<", strings.NewReader(""), &bytes.Buffer{}, cfg); err == nil {
+		t.Error("Expected an error moving past the start of the tape")
+	}
+}
+
+func TestUnbalanced(t *testing.T) {
+	if err := interp.Run("[[]", strings.NewReader(""), &bytes.Buffer{}, interp.DefaultConfig); err != interp.ErrUnbalanced {
+		t.Errorf("Expected ErrUnbalanced, got %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+package layout
+
+import (
+	"asm"
+	"sort"
+)
+
+// Cost is the number of < and > needed to visit the cells in order, starting
+// from cell zero, once every cell has been moved to its place.
+func Cost(accesses []asm.Pointer, place map[asm.Pointer]asm.Pointer) int {
+	cost, pc := 0, asm.ZeroPointer
+	for _, pt := range accesses {
+		if to, ok := place[pt]; ok {
+			pt = to
+		}
+
+		cost += distance(int(pc), int(pt))
+		pc = pt
+	}
+
+	return cost
+}
+
+type edge struct {
+	to, weight int
+}
+
+type graph struct {
+	adj   [][]edge
+	first int // The first cell visited, which is reached from cell zero
+}
+
+// Optimal places every accessed cell so that the pointer moves as little as
+// possible. The problem is NP-hard, so this improves both the original order
+// and the order of first use by swapping pairs of cells until no swap helps,
+// and keeps whichever ends up cheaper.
+func Optimal(accesses []asm.Pointer) map[asm.Pointer]asm.Pointer {
+	cells, index := distinctCells(accesses)
+	g := buildGraph(accesses, index, len(cells))
+
+	original := make([]int, len(cells))
+	for i := range original {
+		original[i] = i
+	}
+
+	byFirstUse := make([]int, len(cells))
+	for i, cell := range firstUse(accesses, index) {
+		byFirstUse[cell] = i
+	}
+
+	best := g.improve(original)
+	if other := g.improve(byFirstUse); g.cost(other) < g.cost(best) {
+		best = other
+	}
+
+	place := make(map[asm.Pointer]asm.Pointer, len(cells))
+	for i, cell := range cells {
+		place[cell] = asm.Pointer(best[i])
+	}
+
+	return place
+}
+
+// distinctCells sorts the cells that were accessed, ignoring any null pointers
+// left behind by compiler errors.
+func distinctCells(accesses []asm.Pointer) ([]asm.Pointer, map[asm.Pointer]int) {
+	seen := make(map[asm.Pointer]bool)
+	cells := make([]asm.Pointer, 0, 10)
+	for _, pt := range accesses {
+		if pt >= 0 && !seen[pt] {
+			seen[pt] = true
+			cells = append(cells, pt)
+		}
+	}
+	sort.Slice(cells, func(i, j int) bool { return cells[i] < cells[j] })
+
+	index := make(map[asm.Pointer]int, len(cells))
+	for i, pt := range cells {
+		index[pt] = i
+	}
+
+	return cells, index
+}
+
+func firstUse(accesses []asm.Pointer, index map[asm.Pointer]int) []int {
+	seen := make(map[int]bool)
+	order := make([]int, 0, len(index))
+	for _, pt := range accesses {
+		if i, ok := index[pt]; ok && !seen[i] {
+			seen[i] = true
+			order = append(order, i)
+		}
+	}
+
+	return order
+}
+
+func buildGraph(accesses []asm.Pointer, index map[asm.Pointer]int, n int) *graph {
+	weights := make([]map[int]int, n)
+	for i := range weights {
+		weights[i] = make(map[int]int)
+	}
+
+	g := &graph{adj: make([][]edge, n), first: -1}
+	prev := -1
+	for _, pt := range accesses {
+		i, ok := index[pt]
+		if !ok {
+			prev = -1
+			continue
+		}
+
+		if g.first < 0 {
+			g.first = i
+		}
+		if prev >= 0 && prev != i {
+			weights[prev][i]++
+			weights[i][prev]++
+		}
+		prev = i
+	}
+
+	for i, ws := range weights {
+		for j, w := range ws {
+			g.adj[i] = append(g.adj[i], edge{j, w})
+		}
+		sort.Slice(g.adj[i], func(a, b int) bool { return g.adj[i][a].to < g.adj[i][b].to })
+	}
+
+	return g
+}
+
+// local is the movement caused by the edges touching cell i
+func (g *graph) local(pos []int, i int) int {
+	cost := 0
+	if i == g.first {
+		cost += pos[i]
+	}
+
+	for _, e := range g.adj[i] {
+		cost += e.weight * distance(pos[i], pos[e.to])
+	}
+
+	return cost
+}
+
+func (g *graph) cost(pos []int) int {
+	cost := 0
+	for i := range pos {
+		cost += g.local(pos, i)
+	}
+
+	// Every edge was counted from both ends, the start only once
+	if g.first >= 0 {
+		cost += pos[g.first]
+	}
+	return cost / 2
+}
+
+// improve swaps pairs of cells while that lowers the cost
+func (g *graph) improve(start []int) []int {
+	pos := append([]int(nil), start...)
+
+	const maxPasses = 100
+	for pass, improved := 0, true; improved && pass < maxPasses; pass++ {
+		improved = false
+		for i := range pos {
+			for j := i + 1; j < len(pos); j++ {
+				before := g.local(pos, i) + g.local(pos, j)
+				pos[i], pos[j] = pos[j], pos[i]
+				if g.local(pos, i)+g.local(pos, j) < before {
+					improved = true
+				} else {
+					pos[i], pos[j] = pos[j], pos[i]
+				}
+			}
+		}
+	}
+
+	return pos
+}
+
+func distance(a, b int) int {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
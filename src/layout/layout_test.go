@@ -0,0 +1,37 @@
+package layout_test
+
+import (
+	"asm"
+	"layout"
+	"testing"
+)
+
+func TestCostCountsFromZero(t *testing.T) {
+	accesses := []asm.Pointer{2, 0, 3, 3}
+	if cost := layout.Cost(accesses, nil); cost != 7 {
+		t.Errorf("Expected a cost of 7, got %d", cost)
+	}
+}
+
+func TestOptimalPlacesBusyCellsTogether(t *testing.T) {
+	accesses := []asm.Pointer{1, 2, 0, 3, 0, 3, 0, 3, 0, 3}
+	place := layout.Optimal(accesses)
+
+	before, after := layout.Cost(accesses, nil), layout.Cost(accesses, place)
+	if after >= before {
+		t.Errorf("Expected the layout to lower the cost of %d, got %d", before, after)
+	}
+
+	if d := place[0] - place[3]; d != 1 && d != -1 {
+		t.Errorf("Expected cells 0 and 3 to be neighbours, got %v", place)
+	}
+}
+
+func TestOptimalNeverWorse(t *testing.T) {
+	accesses := []asm.Pointer{0, 1, 2, 3, 2, 1, 0}
+	place := layout.Optimal(accesses)
+
+	if before, after := layout.Cost(accesses, nil), layout.Cost(accesses, place); after > before {
+		t.Errorf("Layout made the cost worse, %d to %d", before, after)
+	}
+}
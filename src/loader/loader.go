@@ -0,0 +1,148 @@
+package loader
+
+import (
+	"diag"
+	"io/ioutil"
+	"os"
+	"parse"
+	"path/filepath"
+	"stdlib"
+	"strings"
+)
+
+// Loader parses a program along with every file it imports. An import is
+// looked for next to the file importing it, and then in each of the search
+// paths in order. Modules of the standard library are imported with std and
+// named in diagnostics as if they were in a std directory.
+type Loader struct {
+	paths   []string
+	loaded  map[string]bool
+	loading []string // The files being loaded, each imported by the one before
+	diags   diag.List
+}
+
+func New(paths []string) *Loader {
+	return &Loader{paths: paths, loaded: make(map[string]bool)}
+}
+
+// Load parses the source of the file at path, replacing every import at the
+// top level with the statements of the file it names. A file that has already
+// been imported is left out the next time.
+func (l *Loader) Load(path string, src []byte) (parse.StmtCollection, diag.List) {
+	stmts := l.load(path, canonical(path), src)
+	return stmts, l.diags
+}
+
+// load parses src, with key being the one name the file goes by however it
+// was reached
+func (l *Loader) load(path string, key string, src []byte) parse.StmtCollection {
+	l.loaded[key] = true
+	l.loading = append(l.loading, key)
+	defer func() { l.loading = l.loading[:len(l.loading)-1] }()
+
+	stmts, diags := parse.Parse(parse.LexFile(path, string(src)))
+	l.diags = append(l.diags, diags...)
+
+	for i, stmt := range stmts {
+		if imp, isImport := stmt.Expr.(parse.ImportStmt); isImport {
+			stmts[i].Expr = l.include(path, key, imp, stmt.Pos)
+		}
+	}
+
+	return stmts
+}
+
+// include is the statements of an imported file, or none if there's nothing
+// new to include
+func (l *Loader) include(from string, fromKey string, imp parse.ImportStmt, pos diag.Pos) parse.StmtCollection {
+	var path, key string
+	if imp.Std {
+		path = filepath.Join("std", imp.Path+".bfu")
+		key = stdKey + imp.Path
+	} else {
+		// The standard library has no directory of its own to look in
+		dir := filepath.Dir(from)
+		if strings.HasPrefix(fromKey, stdKey) {
+			dir = ""
+		}
+
+		var ok bool
+		if path, ok = l.find(dir, imp.Path); !ok {
+			l.diags.Errorf(pos, "Cannot find %q to import", imp.Path)
+			return nil
+		}
+		key = canonical(path)
+	}
+
+	for i, loading := range l.loading {
+		if loading == key {
+			cycle := append(l.loading[i:len(l.loading):len(l.loading)], key)
+			l.diags.Errorf(pos, "Import cycle: %s", strings.Join(cycle, " imports "))
+			return nil
+		}
+	}
+
+	if l.loaded[key] {
+		return nil
+	}
+
+	if imp.Std {
+		src, err := stdlib.Source(imp.Path)
+		if err != nil {
+			l.diags.Errorf(pos, "There is no %q in the standard library", imp.Path)
+			return nil
+		}
+		return l.load(path, key, src)
+	}
+
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		l.diags.Errorf(pos, "Cannot import %q: %v", imp.Path, err)
+		return nil
+	}
+
+	return l.load(path, key, src)
+}
+
+// find is where the file named by an import is, looking in dir first unless
+// it's empty
+func (l *Loader) find(dir string, name string) (string, bool) {
+	if filepath.IsAbs(name) {
+		return name, exists(name)
+	}
+
+	dirs := l.paths
+	if dir != "" {
+		dirs = append([]string{dir}, l.paths...)
+	}
+
+	for _, dir := range dirs {
+		if path := filepath.Join(dir, name); exists(path) {
+			return path, true
+		}
+	}
+
+	return "", false
+}
+
+// stdKey starts the key of every module of the standard library, which can't
+// be the start of a canonical path
+const stdKey = "std:"
+
+func exists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// canonical is the one name a file goes by, however it was reached
+func canonical(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		path = real
+	}
+
+	return path
+}
@@ -0,0 +1,126 @@
+package loader_test
+
+import (
+	"io/ioutil"
+	"loader"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles puts each file under a new directory, returning its path
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "loader")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, src := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func load(t *testing.T, dir string, paths []string, main string) (string, string) {
+	path := filepath.Join(dir, "main.bfu")
+	stmts, diags := loader.New(paths).Load(path, []byte(main))
+	return stmts.String(), diags.String()
+}
+
+func TestImportRelative(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"lib/a.bfu": `import "b.bfu"; var $a;`,
+		"lib/b.bfu": `var $b;`,
+	})
+	defer os.RemoveAll(dir)
+
+	ast, diags := load(t, dir, nil, `import "lib/a.bfu"; var $main;`)
+	if diags != "" {
+		t.Fatalf("Unexpected errors:\n%v", diags)
+	}
+
+	if ast != "var [$b];;var [$a];;var [$main];" {
+		t.Errorf("Unexpected program %v", ast)
+	}
+}
+
+func TestImportSearchPath(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"first/a.bfu":  `var $first;`,
+		"second/a.bfu": `var $second;`,
+		"second/b.bfu": `var $b;`,
+	})
+	defer os.RemoveAll(dir)
+
+	paths := []string{filepath.Join(dir, "first"), filepath.Join(dir, "second")}
+	ast, diags := load(t, dir, paths, `import "a.bfu"; import "b.bfu";`)
+	if diags != "" {
+		t.Fatalf("Unexpected errors:\n%v", diags)
+	}
+
+	if ast != "var [$first];;var [$b];;" {
+		t.Errorf("Unexpected program %v", ast)
+	}
+}
+
+func TestImportOnce(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.bfu": `import "c.bfu"; var $a;`,
+		"b.bfu": `import "./c.bfu"; var $b;`,
+		"c.bfu": `var $c;`,
+	})
+	defer os.RemoveAll(dir)
+
+	ast, diags := load(t, dir, nil, `import "a.bfu"; import "b.bfu"; import "a.bfu";`)
+	if diags != "" {
+		t.Fatalf("Unexpected errors:\n%v", diags)
+	}
+
+	if ast != "var [$c];;var [$a];;;var [$b];;;" {
+		t.Errorf("Unexpected program %v", ast)
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.bfu":   `import "b.bfu";`,
+		"b.bfu":   `import "a.bfu";`,
+		"bad.bfu": `var $a`,
+	})
+	defer os.RemoveAll(dir)
+
+	_, diags := load(t, dir, nil, `import "a.bfu"; import "missing.bfu"; import "bad.bfu";`)
+	for _, expected := range []string{
+		"b.bfu:1:1: error: Import cycle: " + filepath.Join(dir, "a.bfu") + " imports " + filepath.Join(dir, "b.bfu") + " imports " + filepath.Join(dir, "a.bfu"),
+		"main.bfu:1:17: error: Cannot find \"missing.bfu\" to import",
+		"bad.bfu:1:7: error:",
+	} {
+		if !strings.Contains(diags, expected) {
+			t.Errorf("Expected %q in:\n%v", expected, diags)
+		}
+	}
+}
+
+func TestImportStd(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.bfu": `import std "mem";`,
+	})
+	defer os.RemoveAll(dir)
+
+	ast, diags := load(t, dir, nil, `import std "mem"; import "a.bfu"; import std "nope";`)
+	if !strings.Contains(ast, "def $swap") || strings.Count(ast, "def $swap") != 1 {
+		t.Errorf("Expected $swap to be imported once, got %v", ast)
+	}
+
+	if expected := "main.bfu:1:35: error: There is no \"nope\" in the standard library"; !strings.Contains(diags, expected) {
+		t.Errorf("Expected %q in:\n%v", expected, diags)
+	}
+}
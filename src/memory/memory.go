@@ -1,22 +1,104 @@
 package memory
 
-type Memory [100]bool
+import "errors"
 
-func New() *Memory {
-	return &Memory{}
+// DefaultLimit is the tape length most brainfuck implementations provide
+const DefaultLimit = 30000
+
+var ErrFull error = errors.New("Memory is full")
+
+// Memory tracks which cells of the tape are in use. The tape grows on demand
+// until it reaches limit cells.
+type Memory struct {
+	cells []bool
+	limit int
+}
+
+func New(limit int) *Memory {
+	return &Memory{make([]bool, 0, 100), limit}
+}
+
+// Malloc reserves the free cell closest to near, preferring the lower cell
+// when two are equally close. A negative near means the lowest free cell.
+func (m *Memory) Malloc(near int) (int, error) {
+	if near < 0 {
+		near = 0
+	}
+
+	best := -1
+	for i := range m.cells {
+		if !m.cells[i] && (best < 0 || distance(i, near) < distance(best, near)) {
+			best = i
+		}
+	}
+
+	end := len(m.cells)
+	if end < m.limit && (best < 0 || distance(end, near) < distance(best, near)) {
+		m.cells = append(m.cells, false)
+		best = end
+	}
+
+	if best < 0 {
+		return -1, ErrFull
+	}
+
+	m.cells[best] = true
+	return best, nil
 }
 
-func (m *Memory) Malloc(near int) int {
-	for i := 0; i < len(m); i++ {
-		if !m[i] {
-			m[i] = true
-			return i
+// MallocBlock reserves n neighbouring cells, returning the first of them. It
+// picks the block starting closest to near, growing the tape if it has to.
+func (m *Memory) MallocBlock(n int, near int) (int, error) {
+	if near < 0 {
+		near = 0
+	}
+
+	best := -1
+	for start, run := 0, 0; start+n <= m.limit && start <= len(m.cells); start++ {
+		// run is how many cells from start are free, up to n
+		if run > 0 {
+			run--
+		}
+		for run < n && (start+run >= len(m.cells) || !m.cells[start+run]) {
+			run++
+		}
+
+		if run == n && (best < 0 || distance(start, near) < distance(best, near)) {
+			best = start
 		}
 	}
 
-	panic("Memory is full!")
+	if best < 0 {
+		return -1, ErrFull
+	}
+
+	for len(m.cells) < best+n {
+		m.cells = append(m.cells, false)
+	}
+	for i := best; i < best+n; i++ {
+		m.cells[i] = true
+	}
+
+	return best, nil
 }
 
 func (m *Memory) Free(p int) {
-	m[p] = false
+	m.cells[p] = false
+}
+
+// Len is the number of cells the tape has grown to
+func (m *Memory) Len() int {
+	return len(m.cells)
+}
+
+// Limit is the number of cells the tape can grow to
+func (m *Memory) Limit() int {
+	return m.limit
+}
+
+func distance(a, b int) int {
+	if a > b {
+		return a - b
+	}
+	return b - a
 }
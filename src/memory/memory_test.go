@@ -0,0 +1,87 @@
+package memory_test
+
+import (
+	"memory"
+	"testing"
+)
+
+func expectMalloc(t *testing.T, m *memory.Memory, near, expected int) {
+	pt, err := m.Malloc(near)
+	if err != nil {
+		t.Fatalf("Unexpected error from Malloc: %v", err)
+	}
+
+	if pt != expected {
+		t.Errorf("Malloc(%d) returned %d, expected %d", near, pt, expected)
+	}
+}
+
+func TestMallocFirstFree(t *testing.T) {
+	m := memory.New(10)
+	expectMalloc(t, m, -1, 0)
+	expectMalloc(t, m, -1, 1)
+	expectMalloc(t, m, -1, 2)
+
+	m.Free(1)
+	expectMalloc(t, m, -1, 1)
+}
+
+func TestMallocNear(t *testing.T) {
+	m := memory.New(10)
+	for i := 0; i < 6; i++ {
+		expectMalloc(t, m, -1, i)
+	}
+
+	m.Free(1)
+	m.Free(4)
+	expectMalloc(t, m, 3, 4)
+	expectMalloc(t, m, 3, 1)
+	expectMalloc(t, m, 3, 6)
+	expectMalloc(t, m, 20, 7)
+}
+
+func TestMallocLimit(t *testing.T) {
+	m := memory.New(2)
+	expectMalloc(t, m, -1, 0)
+	expectMalloc(t, m, -1, 1)
+
+	if _, err := m.Malloc(-1); err != memory.ErrFull {
+		t.Errorf("Expected ErrFull once the tape is used up, got %v", err)
+	}
+	if m.Limit() != 2 {
+		t.Errorf("Expected a limit of 2, got %d", m.Limit())
+	}
+}
+
+func TestMallocBlock(t *testing.T) {
+	m := memory.New(20)
+	for i := 0; i < 8; i++ {
+		expectMalloc(t, m, -1, i)
+	}
+
+	m.Free(1)
+	m.Free(2)
+	m.Free(4)
+	m.Free(5)
+	m.Free(6)
+
+	expectBlock := func(n, near, expected int) {
+		start, err := m.MallocBlock(n, near)
+		if err != nil {
+			t.Fatalf("Unexpected error from MallocBlock: %v", err)
+		}
+		if start != expected {
+			t.Errorf("MallocBlock(%d, %d) returned %d, expected %d", n, near, start, expected)
+		}
+	}
+
+	expectBlock(3, 0, 4)
+	expectBlock(2, 10, 8)
+	expectBlock(2, 0, 1)
+	expectBlock(5, 0, 10)
+	expectMalloc(t, m, -1, 15)
+
+	if _, err := m.MallocBlock(5, 0); err != memory.ErrFull {
+		t.Errorf("Expected the tape to be full, got %v", err)
+	}
+}
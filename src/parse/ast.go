@@ -1,21 +1,34 @@
 package parse
 
 import (
+	"diag"
 	"fmt"
+	"strconv"
 	"strings"
 )
 
 type Expr interface {
 	String() string
 }
+
+// Positioned is implemented by the nodes that know where they came from
+type Positioned interface {
+	Position() diag.Pos
+}
+
 type Stmt struct {
 	Expr Expr
+	Pos  diag.Pos
 }
 
 func (s Stmt) String() string {
 	return s.Expr.String() + ";"
 }
 
+func (s Stmt) Position() diag.Pos {
+	return s.Pos
+}
+
 type StmtCollection []Stmt
 
 func (s StmtCollection) String() string {
@@ -34,34 +47,173 @@ const (
 	Add
 	Sub
 	Floor
+	Mul
 )
 
 type Ident struct {
-	Op IdentifierOp
-	Id string
+	Op    IdentifierOp
+	Id    string
+	Index Expr // The element of an array, or its size when declaring it
+	Pos   diag.Pos
+}
+
+func (i Ident) Position() diag.Pos {
+	return i.Pos
 }
 
 func (i Ident) String() string {
+	id := i.Id
+	if i.Index != nil {
+		id = fmt.Sprintf("%v[%v]", i.Id, i.Index)
+	}
+
 	switch i.Op {
 	case Add:
-		return "+" + i.Id
+		return "+" + id
 	case Sub:
-		return "-" + i.Id
+		return "-" + id
 	case Floor:
-		return "_" + i.Id
+		return "_" + id
+	case Mul:
+		return "*" + id
 	default:
-		return i.Id
+		return id
 	}
 }
 
 type Lit struct {
 	Val int
+	Pos diag.Pos
 }
 
 func (l Lit) String() string {
 	return fmt.Sprintf("%v", l.Val)
 }
 
+func (l Lit) Position() diag.Pos {
+	return l.Pos
+}
+
+// Str is a string of bytes, which can only be printed
+type Str struct {
+	Val string
+}
+
+func (s Str) String() string {
+	return strconv.Quote(s.Val)
+}
+
+type ArithOp int
+
+const (
+	Multiply ArithOp = iota
+	Divide
+	Modulo
+)
+
+func (o ArithOp) String() string {
+	switch o {
+	case Multiply:
+		return "*"
+	case Divide:
+		return "/"
+	case Modulo:
+		return "%"
+	default:
+		return "?"
+	}
+}
+
+// Arith is a binary operation between two literals or identifiers
+type Arith struct {
+	Op  ArithOp
+	Lhs Expr
+	Rhs Expr
+}
+
+func (a Arith) String() string {
+	return fmt.Sprintf("%v %v %v", a.Lhs, a.Op, a.Rhs)
+}
+
+type CompareOp int
+
+const (
+	Equal CompareOp = iota
+	NotEqual
+	Less
+	Greater
+	LessEqual
+	GreaterEqual
+)
+
+func (o CompareOp) String() string {
+	switch o {
+	case Equal:
+		return "=="
+	case NotEqual:
+		return "!="
+	case Less:
+		return "<"
+	case Greater:
+		return ">"
+	case LessEqual:
+		return "<="
+	case GreaterEqual:
+		return ">="
+	default:
+		return "?"
+	}
+}
+
+// Comparison is a condition between two literals or identifiers
+type Comparison struct {
+	Op  CompareOp
+	Lhs Expr
+	Rhs Expr
+}
+
+func (c Comparison) String() string {
+	return fmt.Sprintf("%v %v %v", c.Lhs, c.Op, c.Rhs)
+}
+
+type LogicalOp int
+
+const (
+	And LogicalOp = iota
+	Or
+)
+
+func (o LogicalOp) String() string {
+	switch o {
+	case And:
+		return "&&"
+	case Or:
+		return "||"
+	default:
+		return "?"
+	}
+}
+
+// Logical combines two conditions, the right hand side is only evaluated when
+// it's needed
+type Logical struct {
+	Op  LogicalOp
+	Lhs Expr
+	Rhs Expr
+}
+
+func (l Logical) String() string {
+	return fmt.Sprintf("(%v %v %v)", l.Lhs, l.Op, l.Rhs)
+}
+
+type Not struct {
+	Expr Expr
+}
+
+func (n Not) String() string {
+	return fmt.Sprintf("!%v", n.Expr)
+}
+
 type Assignment struct {
 	Lhs []Ident
 	Rhs Expr
@@ -73,31 +225,75 @@ func (a Assignment) String() string {
 
 type VarDef struct {
 	Idents []Ident
+	Rhs    Expr // nil when the variables are only declared
 }
 
 func (v VarDef) String() string {
+	if v.Rhs != nil {
+		return fmt.Sprintf("var %v = %v", v.Idents, v.Rhs)
+	}
+
 	return "var " + fmt.Sprintf("%v", v.Idents)
 }
 
-type PrintStmt struct {
+// ConstDef names a value that is worked out when compiling
+type ConstDef struct {
 	Idents []Ident
+	Rhs    Expr
+}
+
+func (c ConstDef) String() string {
+	return fmt.Sprintf("const %v = %v", c.Idents, c.Rhs)
+}
+
+// PrintStmt prints identifiers, literals and strings in order. Decimal prints
+// the numbers in them instead of the characters.
+type PrintStmt struct {
+	Args    []Expr
+	Decimal bool
 }
 
 func (v PrintStmt) String() string {
-	return fmt.Sprintf("print %v", v.Idents)
+	if v.Decimal {
+		return fmt.Sprintf("print num %v", v.Args)
+	}
+
+	return fmt.Sprintf("print %v", v.Args)
+}
+
+// ReadStmt reads a character into each identifier, or a number written in
+// decimal when Decimal is set
+type ReadStmt struct {
+	Idents  []Ident
+	Decimal bool
+}
+
+func (v ReadStmt) String() string {
+	if v.Decimal {
+		return fmt.Sprintf("read num %v", v.Idents)
+	}
+
+	return fmt.Sprintf("read %v", v.Idents)
 }
 
+// IfStmt and WhileStmt test an identifier or literal for being non-zero, or a
+// comparison or combination of them
 type IfStmt struct {
-	Subject Ident
+	Subject Expr
 	Body    StmtCollection
+	Else    StmtCollection // nil when there is no else branch
 }
 
 func (i IfStmt) String() string {
+	if i.Else != nil {
+		return fmt.Sprintf("if %v { %v } else { %v }", i.Subject, i.Body, i.Else)
+	}
+
 	return fmt.Sprintf("if %v { %v }", i.Subject, i.Body)
 }
 
 type WhileStmt struct {
-	Subject Ident
+	Subject Expr
 	Body    StmtCollection
 }
 
@@ -105,6 +301,46 @@ func (w WhileStmt) String() string {
 	return fmt.Sprintf("while %v { %v }", w.Subject, w.Body)
 }
 
+// RepeatStmt runs the body a number of times known when compiling
+type RepeatStmt struct {
+	Count Expr
+	Body  StmtCollection
+}
+
+func (r RepeatStmt) String() string {
+	return fmt.Sprintf("repeat %v { %v }", r.Count, r.Body)
+}
+
+// ImportStmt includes the statements of another file in its place, or of a
+// module of the standard library
+type ImportStmt struct {
+	Path string
+	Std  bool
+}
+
+func (i ImportStmt) String() string {
+	if i.Std {
+		return fmt.Sprintf("import std %q", i.Path)
+	}
+	return fmt.Sprintf("import %q", i.Path)
+}
+
+// BreakStmt leaves the innermost while loop
+type BreakStmt struct{}
+
+func (BreakStmt) String() string {
+	return "break"
+}
+
+// ContinueStmt skips the rest of the body of the innermost while loop
+type ContinueStmt struct{}
+
+func (ContinueStmt) String() string {
+	return "continue"
+}
+
+// FuncDec defines a function. Its parameters are bound to variables by
+// reference, apart from those starting with a # which are bound to literals.
 type FuncDec struct {
 	Name Ident
 	Args []Ident
@@ -115,24 +351,36 @@ func (w FuncDec) String() string {
 	return fmt.Sprintf("def %v(%v) { %v }", w.Name, w.Args, w.Body)
 }
 
+func (w FuncDec) Position() diag.Pos {
+	return w.Name.Pos
+}
+
 type FuncCall struct {
 	Func Ident
-	Args []Ident
+	Args []Expr // Identifiers, or literals for literal parameters
 }
 
 func (f FuncCall) String() string {
 	return fmt.Sprintf("%v(%v)", f.Func, f.Args)
 }
 
+func (f FuncCall) Position() diag.Pos {
+	return f.Func.Pos
+}
+
 type SyntaxError struct {
 	Token   Token
 	Message string
 }
 
 func (s SyntaxError) String() string {
-	return fmt.Sprintf("Syntax Error at %d. %s", s.Token.LineNumber(), s.Message)
+	return fmt.Sprintf("Syntax Error at %v. %s", s.Token.Position(), s.Message)
 }
 
 func (s SyntaxError) Error() string {
 	return s.String()
 }
+
+func (s SyntaxError) Position() diag.Pos {
+	return s.Token.Position()
+}
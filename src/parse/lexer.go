@@ -1,6 +1,7 @@
 package parse
 
 import (
+	"diag"
 	"fmt"
 	"strings"
 	"unicode/utf8"
@@ -17,31 +18,62 @@ const (
 	tokCloseParen
 	tokOpenBrace
 	tokCloseBrace
+	tokOpenBracket
+	tokCloseBracket
 
 	// Punctuation
 	tokSemicolon
 
 	// Literals
 	tokNum
+	tokParam
 	tokChar
+	tokString
 
 	// Assorted
 	tokEquals
 	tokIdent
 
+	// Arithmetic
+	tokStar
+	tokSlash
+	tokPercent
+
+	// Comparison
+	tokEqualEqual
+	tokNotEqual
+	tokLess
+	tokGreater
+	tokLessEqual
+	tokGreaterEqual
+
+	// Logic
+	tokNot
+	tokAnd
+	tokOr
+
 	// Keywords
 	tokKeyword // Used to distinguish keywords for print method
 	tokDef
 	tokWhile
+	tokRepeat
+	tokBreak
+	tokContinue
 	tokIf
 	tokElse
 	tokPrint
+	tokRead
 	tokVar
+	tokDecimal
+	tokConst
+	tokImport
+	tokStd
 )
 
 type Token struct {
 	Type   TokenType
 	Value  string
+	pos    int
 	endPos int
 	lexer  *lexer
 }
@@ -50,6 +82,22 @@ func (t Token) LineNumber() int {
 	return strings.Count(t.lexer.input[0:t.endPos], "\n") + 1
 }
 
+// Position is where the token starts in the source
+func (t Token) Position() diag.Pos {
+	if t.lexer == nil {
+		return diag.Pos{}
+	}
+
+	before := t.lexer.input[0:t.pos]
+	lineStart := strings.LastIndex(before, "\n") + 1
+
+	return diag.Pos{
+		File: t.lexer.name,
+		Line: strings.Count(before, "\n") + 1,
+		Col:  utf8.RuneCountInString(before[lineStart:]) + 1,
+	}
+}
+
 func (t Token) String() string {
 	switch {
 	case t.Type == tokEOF:
@@ -64,6 +112,8 @@ func (t Token) String() string {
 		return fmt.Sprintf("I(%s)", t.Value)
 	case t.Type == tokChar:
 		return fmt.Sprintf("C(%s)", t.Value)
+	case t.Type == tokString:
+		return fmt.Sprintf("S(%s)", t.Value)
 	default:
 		return t.Value
 	}
@@ -88,6 +138,7 @@ func (l *lexer) emit(tokenType TokenType) {
 		Value:  l.input[l.start:l.pos],
 		Type:   tokenType,
 		lexer:  l,
+		pos:    l.start,
 		endPos: l.pos,
 	}
 
@@ -146,17 +197,41 @@ func (l *lexer) current() string {
 	return l.input[l.start:l.pos]
 }
 
+// errorf reports a problem with the current statement, then skips the rest of
+// it so that the statements after it can still be lexed.
 func (l *lexer) errorf(message string, args ...interface{}) stateFn {
 	parserMessage := fmt.Sprintf(message, args...)
+	if current := strings.TrimSpace(l.current()); current != "" {
+		parserMessage = fmt.Sprintf("%s (near %q)", parserMessage, current)
+	}
 
 	l.tokens <- Token{
 		Type:   tokError,
-		Value:  fmt.Sprintf("Message: %s\nToken: %v", parserMessage, l.current()),
+		Value:  parserMessage,
 		lexer:  l,
+		pos:    l.start,
 		endPos: l.pos,
 	}
 
-	return nil
+	l.ignore()
+	return lexRecover
+}
+
+// lexRecover skips up to and including the next semicolon, or up to the next
+// closing brace, whichever comes first.
+func lexRecover(l *lexer) stateFn {
+	for {
+		switch l.next() {
+		case ';':
+			l.start = l.pos - l.width
+			l.emit(tokSemicolon)
+			return lexStatement
+		case '}', eof:
+			l.backup()
+			l.ignore()
+			return lexStatement
+		}
+	}
 }
 
 // stateFn represents the state of the scanner
@@ -165,6 +240,7 @@ type stateFn func(*lexer) stateFn
 
 const letterChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 const numberChars = "0123456789"
+const hexChars = "0123456789abcdefABCDEF"
 const newLine = '\n'
 
 func isLetter(c rune) bool {
@@ -188,6 +264,25 @@ func grabIdentifier(l *lexer, prefixes string) bool {
 	}
 
 	l.emit(tokIdent)
+	return grabIndex(l)
+}
+
+// grabIndex grabs the index after an array, if there is one
+func grabIndex(l *lexer) bool {
+	if !l.accept("[") {
+		return true
+	}
+	l.emit(tokOpenBracket)
+
+	if !grabLiteral(l) && !grabIdentifier(l, "_") {
+		return false
+	}
+
+	l.skipWhitespace()
+	if !l.accept("]") {
+		return false
+	}
+	l.emit(tokCloseBracket)
 	return true
 }
 
@@ -204,7 +299,7 @@ func lexStatement(l *lexer) stateFn {
 		return lexStatement
 	case '#':
 		return lexComment
-	case '$', '+', '-':
+	case '$', '+', '-', '*':
 		l.backup()
 		return lexIdentifier
 	default:
@@ -218,7 +313,7 @@ func lexStatement(l *lexer) stateFn {
 }
 
 func lexComment(l *lexer) stateFn {
-	for l.next() != newLine {
+	for r := l.next(); r != newLine && r != eof; r = l.next() {
 	}
 	l.ignore()
 
@@ -244,15 +339,37 @@ func lexKeyword(l *lexer) stateFn {
 	case "while":
 		l.emit(tokWhile)
 		return lexControlStatement
+	case "repeat":
+		l.emit(tokRepeat)
+		return lexRepeat
+	case "break":
+		l.emit(tokBreak)
+		return lexEndStatement
+	case "continue":
+		l.emit(tokContinue)
+		return lexEndStatement
+	case "else":
+		l.emit(tokElse)
+		return lexOpenBrace
 	case "def":
 		l.emit(tokDef)
 		return lexFunctionDefinition
 	case "print":
 		l.emit(tokPrint)
+		return lexPrint
+	case "read":
+		l.emit(tokRead)
+		grabDecimal(l)
 		return lexVar
 	case "var":
 		l.emit(tokVar)
-		return lexVar
+		return lexVarDef
+	case "const":
+		l.emit(tokConst)
+		return lexVarDef
+	case "import":
+		l.emit(tokImport)
+		return lexImport
 	default:
 		return l.errorf("Unknown keyword (%v)", l.current())
 	}
@@ -267,6 +384,89 @@ func lexVar(l *lexer) stateFn {
 	return lexEndStatement
 }
 
+// lexPrint lexes a comma separated list of variables, literals and strings
+func lexPrint(l *lexer) stateFn {
+	grabDecimal(l)
+	for {
+		if !grabString(l) && !grabLiteral(l) && !grabIdentifier(l, "") {
+			return l.errorf("Expected a variable, literal or string to print")
+		}
+
+		l.skipWhitespace()
+		if !l.accept(",") {
+			return lexEndStatement
+		}
+	}
+}
+
+// grabDecimal grabs the num after print or read, which works in decimal
+func grabDecimal(l *lexer) {
+	l.skipWhitespace()
+	if l.acceptRun(letterChars) == 0 {
+		return
+	}
+
+	if l.current() == "num" {
+		l.emit(tokDecimal)
+	} else {
+		l.pos = l.start
+	}
+}
+
+// grabString emits the contents of a double quoted string, leaving any escapes
+// for the parser
+func grabString(l *lexer) bool {
+	l.skipWhitespace()
+	if !l.accept("\"") {
+		return false
+	}
+	l.ignore()
+
+	for {
+		switch l.next() {
+		case '\\':
+			l.next()
+		case '"':
+			l.backup()
+			l.emit(tokString)
+			l.next()
+			l.ignore()
+			return true
+		case newLine, eof:
+			return false
+		}
+	}
+}
+
+func lexImport(l *lexer) stateFn {
+	l.skipWhitespace()
+	if strings.HasPrefix(l.input[l.pos:], "std") {
+		l.pos += len("std")
+		l.emit(tokStd)
+	}
+
+	if !grabString(l) {
+		return l.errorf("Expected the file to import in quotes")
+	}
+
+	return lexEndStatement
+}
+
+func lexVarDef(l *lexer) stateFn {
+	varsGrabbed := grabCommaSeperatedArgs(l, "")
+	if varsGrabbed == 0 {
+		return l.errorf("Expected comma seperated arguments list")
+	}
+
+	l.skipWhitespace()
+	if l.accept("=") {
+		l.emit(tokEquals)
+		return lexRhs
+	}
+
+	return lexEndStatement
+}
+
 func lexFunctionDefinition(l *lexer) stateFn {
 	l.skipWhitespace()
 	if !grabIdentifier(l, "") {
@@ -279,7 +479,7 @@ func lexFunctionDefinition(l *lexer) stateFn {
 	}
 
 	l.emit(tokOpenParen)
-	grabCommaSeperatedArgs(l, "")
+	grabArgs(l)
 
 	l.skipWhitespace()
 	if l.next() != ')' {
@@ -289,18 +489,87 @@ func lexFunctionDefinition(l *lexer) stateFn {
 	l.emit(tokCloseParen)
 	l.skipWhitespace()
 	if l.next() != '{' {
-		l.errorf("Expected opening brace")
+		return l.errorf("Expected opening brace")
 	}
 	l.emit(tokOpenBrace)
 
 	return lexStatement
 }
 
+// lexControlStatement lexes the condition of an if or while, up to the brace
 func lexControlStatement(l *lexer) stateFn {
-	if !grabIdentifier(l, "_") {
-		return l.errorf("Expected an identifier")
+	for {
+		for l.skipWhitespace(); ; l.skipWhitespace() {
+			if l.accept("!") {
+				l.emit(tokNot)
+			} else if l.accept("(") {
+				l.emit(tokOpenParen)
+			} else {
+				break
+			}
+		}
+
+		if !grabLiteral(l) && !grabIdentifier(l, "_+-") {
+			return l.errorf("Expected an identifier")
+		}
+
+		if grabComparison(l) && !grabOperand(l) {
+			return l.errorf("Expected identifier or literal")
+		}
+
+		for l.skipWhitespace(); l.accept(")"); l.skipWhitespace() {
+			l.emit(tokCloseParen)
+		}
+
+		switch {
+		case strings.HasPrefix(l.input[l.pos:], "&&"):
+			l.pos += 2
+			l.emit(tokAnd)
+		case strings.HasPrefix(l.input[l.pos:], "||"):
+			l.pos += 2
+			l.emit(tokOr)
+		default:
+			return lexOpenBrace
+		}
 	}
+}
 
+// lexRepeat lexes the number of times to repeat, up to the brace
+func lexRepeat(l *lexer) stateFn {
+	if !grabLiteral(l) && !grabIdentifier(l, "") {
+		return l.errorf("Expected a literal or constant")
+	}
+
+	return lexOpenBrace
+}
+
+// comparisons is checked in order, so the two character operators come first
+var comparisons = []struct {
+	op  string
+	typ TokenType
+}{
+	{"==", tokEqualEqual},
+	{"!=", tokNotEqual},
+	{"<=", tokLessEqual},
+	{">=", tokGreaterEqual},
+	{"<", tokLess},
+	{">", tokGreater},
+}
+
+func grabComparison(l *lexer) bool {
+	l.skipWhitespace()
+	for _, c := range comparisons {
+		if strings.HasPrefix(l.input[l.pos:], c.op) {
+			l.pos += len(c.op)
+			l.emit(c.typ)
+			return true
+		}
+	}
+
+	return false
+}
+
+func lexOpenBrace(l *lexer) stateFn {
 	l.skipWhitespace()
 	if l.next() == '{' {
 		l.emit(tokOpenBrace)
@@ -311,34 +580,97 @@ func lexControlStatement(l *lexer) stateFn {
 }
 
 func lexRhs(l *lexer) stateFn {
+	if !grabOperand(l) {
+		return l.errorf("Expected identifier or literal")
+	}
+
 	l.skipWhitespace()
-	if l.acceptRun(numberChars) > 0 {
-		l.emit(tokNum)
+	switch {
+	case l.accept("*"):
+		l.emit(tokStar)
+	case l.accept("/"):
+		l.emit(tokSlash)
+	case l.accept("%"):
+		l.emit(tokPercent)
+	default:
 		return lexEndStatement
 	}
 
-	if l.next() == '\'' {
-		l.ignore()
-		l.next()
-		l.emit(tokChar)
-		if l.next() != '\'' {
-			return l.errorf("Expected closing quote")
+	if !grabOperand(l) {
+		return l.errorf("Expected identifier or literal")
+	}
+	return lexEndStatement
+}
+
+// grabOperand emits a number, a quoted character or an identifier
+func grabOperand(l *lexer) bool {
+	return grabLiteral(l) || grabIdentifier(l, "_")
+}
+
+func grabLiteral(l *lexer) bool {
+	l.skipWhitespace()
+	if grabNumber(l) {
+		return true
+	}
+
+	// A literal parameter of a function stands in for the literal passed to it
+	if l.accept("#") {
+		if l.acceptRun(letterChars) == 0 {
+			return false
 		}
+		l.emit(tokParam)
+		return true
+	}
+
+	// The escapes are left for the parser, like in strings
+	if l.accept("'") {
 		l.ignore()
-		return lexEndStatement
+		for {
+			switch l.next() {
+			case '\\':
+				l.next()
+			case '\'':
+				l.backup()
+				l.emit(tokChar)
+				l.next()
+				l.ignore()
+				return true
+			case newLine, eof:
+				return false
+			}
+		}
 	}
 
-	l.backup()
-	if grabIdentifier(l, "_") {
-		return lexEndStatement
+	return false
+}
+
+// grabNumber grabs a decimal, hex or binary number, which may be negative
+func grabNumber(l *lexer) bool {
+	start := l.pos
+	l.accept("-")
+
+	digits := numberChars
+	switch {
+	case strings.HasPrefix(l.input[l.pos:], "0x"):
+		l.pos += len("0x")
+		digits = hexChars
+	case strings.HasPrefix(l.input[l.pos:], "0b"):
+		l.pos += len("0b")
+		digits = "01"
 	}
 
-	return l.errorf("Expected identifier or literal")
+	if l.acceptRun(digits) == 0 {
+		l.pos = start
+		return false
+	}
+
+	l.emit(tokNum)
+	return true
 }
 
 func lexIdentifier(l *lexer) stateFn {
 	firstWasNotOp := l.peek() == '$'
-	argsGrabbed := grabCommaSeperatedArgs(l, "+-")
+	argsGrabbed := grabCommaSeperatedArgs(l, "+-*")
 
 	switch l.next() {
 	case '=':
@@ -349,7 +681,7 @@ func lexIdentifier(l *lexer) stateFn {
 		if firstWasNotOp && argsGrabbed == 1 {
 			l.emit(tokOpenParen)
 
-			grabCommaSeperatedArgs(l, "")
+			grabArgs(l)
 			l.skipWhitespace()
 			if l.next() == ')' {
 				l.emit(tokCloseParen)
@@ -378,9 +710,31 @@ func grabCommaSeperatedArgs(l *lexer, prefixes string) int {
 	}
 }
 
+// grabArgs grabs the arguments of a function call or the parameters of a
+// function, which can be literals as well as identifiers
+func grabArgs(l *lexer) int {
+	for count := 0; ; {
+		if !grabIdentifier(l, "") && !grabLiteral(l) {
+			return count
+		}
+		count++
+
+		l.skipWhitespace()
+		if l.next() != ',' {
+			l.backup()
+			return count
+		}
+	}
+}
+
 func Lex(input string) chan Token {
+	return LexFile("", input)
+}
+
+// LexFile is Lex, naming the file in the positions of the tokens
+func LexFile(name string, input string) chan Token {
 	l := lexer{
-		name:   "barry",
+		name:   name,
 		input:  input,
 		tokens: make(chan Token),
 	}
@@ -1,24 +1,29 @@
 package parse
 
 import (
+	"diag"
 	"fmt"
 	"strconv"
 	"strings"
 )
 
 type parser struct {
-	toks       chan Token
-	buf        [3]Token
-	bufIndex   int
-	errors     [10]string
-	errorIndex int
+	toks     chan Token
+	buf      [3]Token
+	bufIndex int
+	diags    diag.List
 }
 
 func (p *parser) next() Token {
 	if p.bufIndex == 0 {
 		p.buf[2] = p.buf[1]
 		p.buf[1] = p.buf[0]
-		p.buf[0] = <-p.toks
+
+		tok, ok := <-p.toks
+		if !ok {
+			tok = Token{Type: tokEOF}
+		}
+		p.buf[0] = tok
 	} else {
 		p.bufIndex--
 	}
@@ -42,13 +47,11 @@ func (p *parser) peek() Token {
 
 func (p *parser) errorf(tok Token, message string, args ...interface{}) Expr {
 	panic(SyntaxError{tok, fmt.Sprintf(message, args...)})
-
-	return nil
 }
 
 func (p *parser) unexpected(tok Token) Expr {
 	if tok.Type == tokError {
-		return p.errorf(tok, tok.Value)
+		return p.errorf(tok, "%s", tok.Value)
 	}
 
 	return p.errorf(tok, "Unexpected token %v", tok)
@@ -64,47 +67,105 @@ func (p *parser) accept(typ TokenType) string {
 	return tok.Value
 }
 
-func Parse(tokens chan Token) (stmts StmtCollection, er error) {
-	defer func() {
-		if recoveredEr := recover(); recoveredEr != nil {
-			if _, ok := recoveredEr.(SyntaxError); ok {
-				er = recoveredEr.(error)
-			} else {
-				panic(recoveredEr)
-			}
-		}
-	}()
+// Parse builds the statements of a program. Syntax errors don't stop it, the
+// broken statement is skipped and reported in the list of diagnostics.
+func Parse(tokens chan Token) (StmtCollection, diag.List) {
+	p := &parser{toks: tokens}
+	stmts := parseStmts(p, tokEOF)
 
-	stmts = parseStmts(&parser{toks: tokens}, tokEOF)
-	return stmts, er
+	return stmts, p.diags
 }
 
 func parseStmts(p *parser, endToken TokenType) StmtCollection {
 	statements := make([]Stmt, 0, 10)
-	for p.peek().Type != endToken {
-		statements = append(statements, parseStmt(p))
+	for tok := p.peek(); tok.Type != endToken; tok = p.peek() {
+		switch {
+		case tok.Type == tokEOF:
+			p.diags.Errorf(tok.Position(), "Expected } before the end of the file")
+			return statements
+		case tok.Type == tokCloseBrace:
+			p.next()
+			p.diags.Errorf(tok.Position(), "Unexpected }, there is no block to close")
+			continue
+		}
+
+		if stmt, ok := parseStmt(p); ok {
+			statements = append(statements, stmt)
+		}
 	}
 
 	p.accept(endToken)
 	return statements
 }
 
-func parseStmt(p *parser) Stmt {
-	return Stmt{Expr: parseExprStatement(p)}
+func parseStmt(p *parser) (stmt Stmt, ok bool) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			syntaxErr, isSyntaxErr := recovered.(SyntaxError)
+			if !isSyntaxErr {
+				panic(recovered)
+			}
+
+			p.diags.Errorf(syntaxErr.Token.Position(), "%s", syntaxErr.Message)
+			p.sync(syntaxErr.Token)
+			ok = false
+		}
+	}()
+
+	pos := p.peek().Position()
+	return Stmt{Expr: parseExprStatement(p), Pos: pos}, true
+}
+
+// sync skips the rest of a broken statement, up to and including the next
+// semicolon, or up to the closing brace of the block it was in.
+func (p *parser) sync(failed Token) {
+	if p.buf[p.bufIndex] == failed {
+		switch failed.Type {
+		case tokSemicolon:
+			return
+		case tokCloseBrace, tokEOF:
+			p.backup()
+			return
+		}
+	}
+
+	for {
+		switch p.next().Type {
+		case tokSemicolon:
+			return
+		case tokCloseBrace, tokEOF:
+			p.backup()
+			return
+		}
+	}
 }
 
 func parseExprStatement(p *parser) Expr {
 	switch tok := p.next(); tok.Type {
 	case tokVar:
 		return parseVarDef(p)
+	case tokConst:
+		return parseConstDef(p)
 	case tokPrint:
 		return parsePrintStmt(p)
+	case tokRead:
+		return parseReadStmt(p)
 	case tokDef:
 		return parseFuncDef(p)
 	case tokIf:
 		return parseIfStmt(p)
 	case tokWhile:
 		return parseWhileStmt(p)
+	case tokRepeat:
+		return parseRepeatStmt(p)
+	case tokImport:
+		return parseImportStmt(p)
+	case tokBreak:
+		p.accept(tokSemicolon)
+		return BreakStmt{}
+	case tokContinue:
+		p.accept(tokSemicolon)
+		return ContinueStmt{}
 	case tokIdent: //Could be arithmatic or a function call
 		return parseFuncCallOrAssignment(p)
 	default:
@@ -120,7 +181,7 @@ func parseFuncCallOrAssignment(p *parser) Expr {
 	switch nextTok.Type {
 	case tokOpenParen:
 		return parseFuncCall(p)
-	case tokIdent, tokEquals:
+	case tokIdent, tokEquals, tokOpenBracket:
 		return parseAssignment(p)
 	default:
 		p.unexpected(nextTok)
@@ -132,7 +193,7 @@ func parseFuncCall(p *parser) Expr {
 	ident := parseIdent(p)
 	p.accept(tokOpenParen)
 
-	args := parseIdentifierList(p, tokCloseParen)
+	args := parseArgList(p, tokCloseParen)
 	p.accept(tokSemicolon)
 
 	return FuncCall{Func: ident, Args: args}
@@ -147,14 +208,34 @@ func parseAssignment(p *parser) Expr {
 }
 
 func parseAssignmentRhs(p *parser) Expr {
+	lhs := parseOperand(p)
+	switch p.next().Type {
+	case tokStar:
+		return Arith{Op: Multiply, Lhs: lhs, Rhs: parseOperand(p)}
+	case tokSlash:
+		return Arith{Op: Divide, Lhs: lhs, Rhs: parseOperand(p)}
+	case tokPercent:
+		return Arith{Op: Modulo, Lhs: lhs, Rhs: parseOperand(p)}
+	default:
+		p.backup()
+		return lhs
+	}
+}
+
+func parseOperand(p *parser) Expr {
 	switch tok := p.next(); tok.Type {
 	case tokNum:
-		c, _ := strconv.Atoi(tok.Value) // Validated by the lexer already
-		return Lit{Val: c}
+		return Lit{Val: parseNum(p, tok), Pos: tok.Position()}
 	case tokChar:
-		return Lit{Val: int(tok.Value[0])}
+		c, _, tail, err := strconv.UnquoteChar(tok.Value, '\'')
+		if err != nil || tail != "" {
+			p.errorf(tok, "Invalid character '%s'", tok.Value)
+		}
+		return Lit{Val: int(c), Pos: tok.Position()}
+	case tokParam:
+		return asIdent(tok)
 	case tokIdent:
-		ident := asIdent(tok.Value)
+		ident := parseIndex(p, asIdent(tok))
 		if ident.Op != None && ident.Op != Floor {
 			p.unexpected(tok)
 		}
@@ -165,27 +246,136 @@ func parseAssignmentRhs(p *parser) Expr {
 	}
 }
 
+// parseNum parses a decimal, hex or binary number, which may be negative
+func parseNum(p *parser, tok Token) int {
+	digits, sign := tok.Value, 1
+	if strings.HasPrefix(digits, "-") {
+		digits, sign = digits[1:], -1
+	}
+
+	base := 10
+	switch {
+	case strings.HasPrefix(digits, "0x"):
+		digits, base = digits[2:], 16
+	case strings.HasPrefix(digits, "0b"):
+		digits, base = digits[2:], 2
+	}
+
+	n, err := strconv.ParseInt(digits, base, 0)
+	if err != nil {
+		p.errorf(tok, "Invalid number %s", tok.Value)
+	}
+
+	return sign * int(n)
+}
+
+// compareOps maps the comparison tokens onto their operators
+var compareOps = map[TokenType]CompareOp{
+	tokEqualEqual:   Equal,
+	tokNotEqual:     NotEqual,
+	tokLess:         Less,
+	tokGreater:      Greater,
+	tokLessEqual:    LessEqual,
+	tokGreaterEqual: GreaterEqual,
+}
+
+// parseCondition parses the subject of an if or while. From the loosest to the
+// tightest binding that is ||, &&, ! and then comparisons.
+func parseCondition(p *parser) Expr {
+	cond := parseConjunction(p)
+	for p.peek().Type == tokOr {
+		p.next()
+		cond = Logical{Op: Or, Lhs: cond, Rhs: parseConjunction(p)}
+	}
+
+	return cond
+}
+
+func parseConjunction(p *parser) Expr {
+	cond := parseNegation(p)
+	for p.peek().Type == tokAnd {
+		p.next()
+		cond = Logical{Op: And, Lhs: cond, Rhs: parseNegation(p)}
+	}
+
+	return cond
+}
+
+func parseNegation(p *parser) Expr {
+	switch p.peek().Type {
+	case tokNot:
+		p.next()
+		return Not{Expr: parseNegation(p)}
+	case tokOpenParen:
+		p.next()
+		cond := parseCondition(p)
+		p.accept(tokCloseParen)
+		return cond
+	default:
+		return parseComparison(p)
+	}
+}
+
+// parseComparison parses an identifier or literal on its own, or a comparison
+// between two of them
+func parseComparison(p *parser) Expr {
+	lhsTok := p.peek()
+	var lhs Expr
+	if lhsTok.Type == tokIdent {
+		lhs = parseIdent(p)
+	} else {
+		lhs = parseOperand(p)
+	}
+
+	op, isComparison := compareOps[p.peek().Type]
+	if !isComparison {
+		return lhs
+	}
+	p.next()
+
+	if ident, isIdent := lhs.(Ident); isIdent && ident.Op != None && ident.Op != Floor {
+		p.unexpected(lhsTok)
+	}
+
+	return Comparison{Op: op, Lhs: lhs, Rhs: parseOperand(p)}
+}
+
 func parseIfStmt(p *parser) Expr {
-	subject := parseIdent(p)
+	subject := parseCondition(p)
 	p.accept(tokOpenBrace)
 	body := parseStmts(p, tokCloseBrace)
 
-	return IfStmt{Subject: subject, Body: body}
+	var elseBody StmtCollection
+	if p.peek().Type == tokElse {
+		p.accept(tokElse)
+		p.accept(tokOpenBrace)
+		elseBody = parseStmts(p, tokCloseBrace)
+	}
+
+	return IfStmt{Subject: subject, Body: body, Else: elseBody}
 }
 
 func parseWhileStmt(p *parser) Expr {
-	subject := parseIdent(p)
+	subject := parseCondition(p)
 	p.accept(tokOpenBrace)
 	body := parseStmts(p, tokCloseBrace)
 
 	return WhileStmt{Subject: subject, Body: body}
 }
 
+func parseRepeatStmt(p *parser) Expr {
+	count := parseOperand(p)
+	p.accept(tokOpenBrace)
+	body := parseStmts(p, tokCloseBrace)
+
+	return RepeatStmt{Count: count, Body: body}
+}
+
 func parseFuncDef(p *parser) Expr {
 	funcName := parseIdent(p)
 	p.accept(tokOpenParen)
 
-	args := parseIdentifierList(p, tokCloseParen)
+	args := parseParamList(p, tokCloseParen)
 	p.accept(tokOpenBrace)
 
 	body := parseStmts(p, tokCloseBrace)
@@ -194,11 +384,89 @@ func parseFuncDef(p *parser) Expr {
 }
 
 func parseVarDef(p *parser) Expr {
-	return VarDef{Idents: parseIdentifierList(p, tokSemicolon)}
+	idents := make([]Ident, 0, 10)
+	for p.peek().Type == tokIdent {
+		idents = append(idents, parseIdent(p))
+	}
+
+	var rhs Expr
+	if p.peek().Type == tokEquals {
+		p.accept(tokEquals)
+		rhs = parseAssignmentRhs(p)
+	}
+	p.accept(tokSemicolon)
+
+	return VarDef{Idents: idents, Rhs: rhs}
+}
+
+func parseConstDef(p *parser) Expr {
+	idents := make([]Ident, 0, 10)
+	for p.peek().Type == tokIdent {
+		idents = append(idents, parseIdent(p))
+	}
+
+	p.accept(tokEquals)
+	rhs := parseAssignmentRhs(p)
+	p.accept(tokSemicolon)
+
+	return ConstDef{Idents: idents, Rhs: rhs}
+}
+
+// acceptDecimal accepts the num of a print or read, if it's there
+func acceptDecimal(p *parser) bool {
+	if p.peek().Type != tokDecimal {
+		return false
+	}
+
+	p.next()
+	return true
 }
 
 func parsePrintStmt(p *parser) Expr {
-	return PrintStmt{Idents: parseIdentifierList(p, tokSemicolon)}
+	decimal := acceptDecimal(p)
+	args := make([]Expr, 0, 10)
+	for tok := p.next(); tok.Type != tokSemicolon; tok = p.next() {
+		switch tok.Type {
+		case tokString:
+			str, err := strconv.Unquote(`"` + tok.Value + `"`)
+			if err != nil {
+				p.errorf(tok, "Invalid escape in string %q", tok.Value)
+			}
+			args = append(args, Str{Val: str})
+		case tokIdent:
+			args = append(args, parseIndex(p, asIdent(tok)))
+		default:
+			p.backup()
+			args = append(args, parseOperand(p))
+		}
+	}
+
+	return PrintStmt{Args: args, Decimal: decimal}
+}
+
+func parseReadStmt(p *parser) Expr {
+	decimal := acceptDecimal(p)
+	return ReadStmt{Idents: parseIdentifierList(p, tokSemicolon), Decimal: decimal}
+}
+
+func parseImportStmt(p *parser) Expr {
+	std := p.peek().Type == tokStd
+	if std {
+		p.accept(tokStd)
+	}
+
+	tok := p.next()
+	if tok.Type != tokString {
+		p.unexpected(tok)
+	}
+
+	path, err := strconv.Unquote(`"` + tok.Value + `"`)
+	if err != nil {
+		p.errorf(tok, "Invalid escape in string %q", tok.Value)
+	}
+	p.accept(tokSemicolon)
+
+	return ImportStmt{Path: path, Std: std}
 }
 
 func parseIdentifierList(p *parser, endToken TokenType) []Ident {
@@ -208,22 +476,66 @@ func parseIdentifierList(p *parser, endToken TokenType) []Ident {
 			p.unexpected(tok)
 		}
 
-		args = append(args, asIdent(tok.Value))
+		args = append(args, parseIndex(p, asIdent(tok)))
+	}
+
+	return args
+}
+
+// parseArgList parses the arguments of a function call
+func parseArgList(p *parser, endToken TokenType) []Expr {
+	args := make([]Expr, 0, 10)
+	for p.peek().Type != endToken {
+		args = append(args, parseOperand(p))
 	}
+	p.accept(endToken)
 
 	return args
 }
 
+// parseParamList parses the parameters of a function definition, which are
+// identifiers or literal parameters
+func parseParamList(p *parser, endToken TokenType) []Ident {
+	params := make([]Ident, 0, 10)
+	for tok := p.next(); tok.Type != endToken; tok = p.next() {
+		if tok.Type != tokIdent && tok.Type != tokParam {
+			p.unexpected(tok)
+		}
+
+		params = append(params, asIdent(tok))
+	}
+
+	return params
+}
+
 func parseIdent(p *parser) Ident {
-	return asIdent(p.accept(tokIdent))
+	tok := p.next()
+	if tok.Type != tokIdent {
+		p.unexpected(tok)
+	}
+
+	return parseIndex(p, asIdent(tok))
+}
+
+// parseIndex parses the index after an array identifier, if there is one
+func parseIndex(p *parser, ident Ident) Ident {
+	if p.peek().Type != tokOpenBracket {
+		return ident
+	}
+
+	p.next()
+	ident.Index = parseOperand(p)
+	p.accept(tokCloseBracket)
+	return ident
 }
 
-func asIdent(value string) Ident {
+func asIdent(tok Token) Ident {
+	value := tok.Value
 	if len(value) == 0 {
 		return Ident{}
 	}
 
-	return Ident{Op: getOp(value), Id: trimOp(value)}
+	return Ident{Op: getOp(value), Id: trimOp(value), Pos: tok.Position()}
 }
 
 func getOp(identifier string) IdentifierOp {
@@ -234,11 +546,13 @@ func getOp(identifier string) IdentifierOp {
 		return Add
 	case '-':
 		return Sub
+	case '*':
+		return Mul
 	default:
 		return None
 	}
 }
 
 func trimOp(identifier string) string {
-	return strings.TrimLeft(identifier, "+-_")
+	return strings.TrimLeft(identifier, "+-_*")
 }
@@ -0,0 +1,144 @@
+package parse_test
+
+import (
+	"parse"
+	"testing"
+)
+
+func TestParseCollectsErrors(t *testing.T) {
+	source := "var $a;\nprint $a\n$a = ;\nwhile $a {\n\tread ;\n}\nprint $a;\n"
+	stmts, diags := parse.Parse(parse.LexFile("test.bfu", source))
+
+	expected := []struct{ line, col int }{{3, 1}, {5, 7}}
+	if len(diags) != len(expected) {
+		t.Fatalf("Expected %d errors, got:\n%v", len(expected), diags)
+	}
+
+	for i, pos := range expected {
+		if d := diags[i]; d.File != "test.bfu" || d.Line != pos.line || d.Col != pos.col {
+			t.Errorf("Expected error %d at %d:%d, got %v", i, pos.line, pos.col, d)
+		}
+	}
+
+	if len(stmts) != 3 {
+		t.Errorf("Expected the var, while and last print to survive, got %v", stmts)
+	}
+}
+
+func TestParseUnclosedBlock(t *testing.T) {
+	_, diags := parse.Parse(parse.Lex("var $a; if $a { print $a;"))
+	if !diags.HasErrors() {
+		t.Error("Expected an error for the missing }")
+	}
+}
+
+func TestParseComparison(t *testing.T) {
+	stmts, diags := parse.Parse(parse.Lex("while $c != 'q' { read $c; } if 3 <= _$a { }"))
+	if diags.HasErrors() {
+		t.Fatalf("Unexpected errors:\n%v", diags)
+	}
+
+	expected := []parse.Expr{
+		parse.Comparison{Op: parse.NotEqual, Lhs: parse.Ident{Id: "$c"}, Rhs: parse.Lit{Val: 'q'}},
+		parse.Comparison{Op: parse.LessEqual, Lhs: parse.Lit{Val: 3}, Rhs: parse.Ident{Op: parse.Floor, Id: "$a"}},
+	}
+	subjects := []parse.Expr{stmts[0].Expr.(parse.WhileStmt).Subject, stmts[1].Expr.(parse.IfStmt).Subject}
+	for i, subject := range subjects {
+		if subject.String() != expected[i].String() {
+			t.Errorf("Expected %v, got %v", expected[i], subject)
+		}
+	}
+}
+
+func TestParseWhileSteps(t *testing.T) {
+	stmts, diags := parse.Parse(parse.Lex("while -$n { } while +$n { } while _$n { }"))
+	if diags.HasErrors() {
+		t.Fatalf("Unexpected errors:\n%v", diags)
+	}
+
+	for i, op := range []parse.IdentifierOp{parse.Sub, parse.Add, parse.Floor} {
+		expected := parse.Ident{Op: op, Id: "$n"}
+		if subject := stmts[i].Expr.(parse.WhileStmt).Subject; subject.String() != expected.String() {
+			t.Errorf("Expected %v, got %v", expected, subject)
+		}
+	}
+}
+
+func TestParseLogicalPrecedence(t *testing.T) {
+	stmts, diags := parse.Parse(parse.Lex("if !$a || $b == 1 && ($c || !($d < 2)) { }"))
+	if diags.HasErrors() {
+		t.Fatalf("Unexpected errors:\n%v", diags)
+	}
+
+	expected := "(!$a || ($b == 1 && ($c || !$d < 2)))"
+	if subject := stmts[0].Expr.(parse.IfStmt).Subject; subject.String() != expected {
+		t.Errorf("Expected %v, got %v", expected, subject)
+	}
+}
+
+func TestParsePrintArgs(t *testing.T) {
+	stmts, diags := parse.Parse(parse.Lex(`print "a \"b\"\n", 'c', 10, $d;`))
+	if diags.HasErrors() {
+		t.Fatalf("Unexpected errors:\n%v", diags)
+	}
+
+	args := stmts[0].Expr.(parse.PrintStmt).Args
+	expected := []parse.Expr{parse.Str{Val: "a \"b\"\n"}, parse.Lit{Val: 'c'}, parse.Lit{Val: 10}, parse.Ident{Id: "$d"}}
+	if len(args) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, args)
+	}
+
+	for i, arg := range args {
+		if arg.String() != expected[i].String() {
+			t.Errorf("Expected %v, got %v", expected[i], arg)
+		}
+	}
+}
+
+func TestParseLiteralParams(t *testing.T) {
+	stmts, diags := parse.Parse(parse.Lex(`def $addN($v, #n) { +$v = #n; } $addN($a, 'x');`))
+	if diags.HasErrors() {
+		t.Fatalf("Unexpected errors:\n%v", diags)
+	}
+
+	if def := stmts[0].Expr.(parse.FuncDec); def.Args[1].Id != "#n" {
+		t.Errorf("Expected a literal parameter #n, got %v", def.Args[1])
+	}
+
+	call := stmts[1].Expr.(parse.FuncCall)
+	if lit, isLit := call.Args[1].(parse.Lit); !isLit || lit.Val != 'x' {
+		t.Errorf("Expected the literal 'x', got %v", call.Args[1])
+	}
+}
+
+func TestParseLiterals(t *testing.T) {
+	stmts, diags := parse.Parse(parse.Lex(`print 65, 0x41, 0b1000001, -3, 'A', '\n', '\t', '\\', '\'', '\x41';`))
+	if diags.HasErrors() {
+		t.Fatalf("Unexpected errors:\n%v", diags)
+	}
+
+	args := stmts[0].Expr.(parse.PrintStmt).Args
+	expected := []int{65, 65, 65, -3, 'A', '\n', '\t', '\\', '\'', 'A'}
+	if len(args) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, args)
+	}
+
+	for i, arg := range args {
+		if lit, isLit := arg.(parse.Lit); !isLit || lit.Val != expected[i] {
+			t.Errorf("Expected %v, got %v", expected[i], arg)
+		}
+	}
+}
+
+func TestParseBadLiterals(t *testing.T) {
+	for _, source := range []string{
+		`print '\q';`,
+		`print 'ab';`,
+		`print 99999999999999999999;`,
+		`print 0x;`,
+	} {
+		if _, diags := parse.Parse(parse.Lex(source)); !diags.HasErrors() {
+			t.Errorf("Expected an error parsing %v", source)
+		}
+	}
+}
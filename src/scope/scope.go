@@ -7,6 +7,10 @@ type Variable struct {
 	Value interface{}
 }
 
+// Constant is the value of a name that is known when the program is compiled,
+// so it never has a cell of its own
+type Constant int
+
 type Scope struct {
 	parent *Scope
 	vars   []Variable
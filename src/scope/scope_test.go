@@ -77,3 +77,16 @@ func TestScopeNamePointers(t *testing.T) {
 		}
 	}
 }
+
+func TestScopeConstant(t *testing.T) {
+	sc := scope.New()
+	name := "NEWLINE"
+	if _, err := sc.Define(&name, scope.Constant(10)); err != nil {
+		t.Errorf("Unexpected error from Define: %v", err)
+	}
+
+	v, exists := sc.Enter().Get(name)
+	if c, isConst := v.Value.(scope.Constant); !exists || !isConst || c != 10 {
+		t.Errorf("Expected the constant 10, got %v", v.Value)
+	}
+}
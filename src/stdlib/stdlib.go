@@ -0,0 +1,12 @@
+package stdlib
+
+import "embed"
+
+//go:embed *.bfu
+var modules embed.FS
+
+// Source is the source of the named module of the standard library, which is
+// built into the compiler and imported with import std "name";
+func Source(name string) ([]byte, error) {
+	return modules.ReadFile(name + ".bfu")
+}
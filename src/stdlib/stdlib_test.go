@@ -0,0 +1,139 @@
+package stdlib_test
+
+import (
+	"asm"
+	"bytes"
+	"compiler"
+	"interp"
+	"loader"
+	"strings"
+	"testing"
+)
+
+// expectOutput loads and compiles source along with its imports, then runs it
+func expectOutput(t *testing.T, expected string, source string, input string) {
+	stmts, diags := loader.New(nil).Load("test.bfu", []byte(source))
+	if diags.HasErrors() {
+		t.Fatalf("Unexpected errors:\n%v", diags)
+	}
+
+	assembler, ch := asm.New()
+	go func() {
+		compiler.Compile(assembler, stmts, compiler.DefaultOptions)
+		close(ch)
+	}()
+
+	bf := strings.Builder{}
+	for node := range asm.Optimise(ch) {
+		if d, isErr := asm.Diagnostic(node); isErr {
+			t.Fatalf("Unexpected error compiling %v: %v", source, d)
+		}
+		bf.WriteString(node.ToBF())
+	}
+
+	out := &bytes.Buffer{}
+	if err := interp.Run(bf.String(), strings.NewReader(input), out, interp.DefaultConfig); err != nil {
+		t.Fatalf("Unexpected error running %v: %v", source, err)
+	}
+
+	if out.String() != expected {
+		t.Errorf("\nSource:\t%v\nExpect:\t%q\nActual:\t%q", source, expected, out.String())
+	}
+}
+
+func TestSwap(t *testing.T) {
+	expectOutput(t, "ba", `
+		import std "mem";
+		var $a = 'a';
+		var $b = 'b';
+		$swap($a, $b);
+		print $a, $b;`, "")
+}
+
+func TestCopy(t *testing.T) {
+	expectOutput(t, "xx", `
+		import std "mem";
+		var $a = 'x';
+		var $b;
+		$copy($a, $b);
+		print $a, $b;`, "")
+}
+
+func TestMul(t *testing.T) {
+	expectOutput(t, "42 7", `
+		import std "math";
+		import std "io";
+		var $a = 6;
+		var $b = 7;
+		$mul($a, $b);
+		$printDecimal($a);
+		print ' ';
+		$printDecimal($b);`, "")
+
+	expectOutput(t, "16", `
+		import std "math";
+		var $a = 4;
+		$mul($a, $a);
+		print num $a;`, "")
+}
+
+func TestDivmod(t *testing.T) {
+	expectOutput(t, "14 2 100 7", `
+		import std "math";
+		import std "io";
+		var $n = 100;
+		var $d = 7;
+		var $q, $r;
+		$divmod($n, $d, $q, $r);
+		$printDecimal($q);
+		print ' ';
+		$printDecimal($r);
+		print ' ';
+		$printDecimal($n);
+		print ' ';
+		$printDecimal($d);`, "")
+
+	expectOutput(t, "0 10", `
+		import std "math";
+		var $n = 10;
+		var $d, $q, $r;
+		$divmod($n, $d, $q, $r);
+		print num $q;
+		print ' ';
+		print num $r;`, "")
+}
+
+func TestPrintDecimal(t *testing.T) {
+	expectOutput(t, "0 9 255", `
+		import std "io";
+		var $a;
+		var $b = 9;
+		var $c = 255;
+		$printDecimal($a);
+		print ' ';
+		$printDecimal($b);
+		print ' ';
+		$printDecimal($c);`, "")
+}
+
+func TestToUpper(t *testing.T) {
+	expectOutput(t, "HELLO, WORLD!", `
+		import std "char";
+		var $c;
+		while $c != '!' {
+			read $c;
+			$toUpper($c);
+			print $c;
+		}`, "Hello, World!")
+}
+
+func TestIsDigit(t *testing.T) {
+	expectOutput(t, "0110", `
+		import std "char";
+		var $c, $result;
+		repeat 4 {
+			read $c;
+			$isDigit($c, $result);
+			print num $result;
+		}`, "a09:")
+}